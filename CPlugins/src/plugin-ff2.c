@@ -1,5 +1,7 @@
 #include <ft2build.h>
 #include FT_FREETYPE_H
+#include FT_LCD_FILTER_H
+#include FT_SYNTHESIS_H
 #include <stdint.h>
 #include <stdlib.h>
 #include <stdio.h>
@@ -14,85 +16,252 @@ uint32_t utf8_to_codepoint(const char* utf8) {
 }
 
 static FT_Library ft_lib = NULL;
-static FT_Face fonts[] = {
+// NULL-terminated fallback chain, grown by ft_add_font.
+static FT_Face* fonts = NULL;
+static int font_count = 0;
+// Optional dedicated faces indexed by style: 1 bold, 2 italic, 3 bold italic.
+static FT_Face styled_fonts[] = {
     NULL, NULL, NULL, NULL
 };
+static int ft_height = 0;
+static char ft_primary_path[4096] = "";
 
-int ft_init(char* font0, char* font1, char* font2, int height) {
-    if (FT_Init_FreeType(&ft_lib)) return 0;
-    if (FT_New_Face(ft_lib, font0, 0, &fonts[0]) == 0) {
-        FT_Set_Pixel_Sizes(fonts[0], 0, height);
+// Colour bitmap fonts (CBDT/sbix) only have fixed strikes, pick the smallest one at least as tall as height.
+void ft_size_face(FT_Face face, int height) {
+    if (FT_IS_SCALABLE(face) || !FT_HAS_FIXED_SIZES(face)) {
+        FT_Set_Pixel_Sizes(face, 0, height);
+        return;
+    }
+    int best = 0;
+    for (int i = 1; i < face->num_fixed_sizes; i++) {
+        int h = face->available_sizes[i].height, b = face->available_sizes[best].height;
+        if ((b < height && h > b) || (h >= height && h < b)) best = i;
     }
-    if (FT_New_Face(ft_lib, font1, 0, &fonts[1]) == 0) {
-        FT_Set_Pixel_Sizes(fonts[1], 0, height);
+    FT_Select_Size(face, best);
+}
+
+void ft_set_height(int height) {
+    if (height == ft_height) return;
+    for (int i = 0; fonts && fonts[i]; i++) {
+        ft_size_face(fonts[i], height);
+    }
+    for (int i = 1; i < 4; i++) {
+        if (styled_fonts[i]) ft_size_face(styled_fonts[i], height);
+    }
+    ft_height = height;
+}
+
+int ft_load_style(int style, char* path) {
+    if (style < 1 || style > 3 || styled_fonts[style]) return 0;
+    if (FT_New_Face(ft_lib, path, 0, &styled_fonts[style]) != 0) {
+        styled_fonts[style] = NULL;
+        return 0;
     }
-    if (FT_New_Face(ft_lib, font2, 0, &fonts[2]) == 0) {
-        FT_Set_Pixel_Sizes(fonts[2], 0, height);
+    ft_size_face(styled_fonts[style], ft_height);
+    return 1;
+}
+
+int ft_init(int height) {
+    if (FT_Init_FreeType(&ft_lib)) return 0;
+    fonts = (FT_Face*)calloc(1, sizeof(FT_Face));
+    ft_height = height;
+    return fonts != NULL;
+}
+
+static int ft_append_face(FT_Face face, char* path) {
+    FT_Face* grown = (FT_Face*)realloc(fonts, (font_count + 2) * sizeof(FT_Face));
+    if (!grown) {
+        FT_Done_Face(face);
+        return 0;
     }
+    fonts = grown;
+    fonts[font_count++] = face;
+    fonts[font_count] = NULL;
+    if (font_count == 1) snprintf(ft_primary_path, sizeof(ft_primary_path), "%s", path);
     return 1;
 }
 
+int ft_add_font(char* path) {
+    FT_Face face;
+    if (!fonts || FT_New_Face(ft_lib, path, 0, &face) != 0) return 0;
+    ft_size_face(face, ft_height);
+    return ft_append_face(face, path);
+}
+
+int ft_has_char(uint32_t codepoint) {
+    for (int i = 0; fonts && fonts[i]; i++) {
+        if (FT_Get_Char_Index(fonts[i], codepoint)) return 1;
+    }
+    return 0;
+}
+
+// Writes the codepoints the font at path maps as first/last pairs into out, at most max pairs.
+// Returns the number of pairs the font has, so a caller with a short buffer can retry, or -1 if it does not load.
+int ft_font_ranges(char* path, uint32_t* out, int max) {
+    FT_Face face;
+    FT_UInt index;
+    int count = 0;
+    if (!ft_lib || FT_New_Face(ft_lib, path, 0, &face) != 0) return -1;
+    FT_ULong cp = FT_Get_First_Char(face, &index);
+    while (index) {
+        FT_ULong first = cp, last = cp;
+        for (cp = FT_Get_Next_Char(face, cp, &index); index && cp == last + 1; cp = FT_Get_Next_Char(face, cp, &index)) last = cp;
+        if (count < max) {
+            out[2 * count] = first;
+            out[2 * count + 1] = last;
+        }
+        count++;
+    }
+    FT_Done_Face(face);
+    return count;
+}
+
 void ft_cleanup() {
-    for (int i = 0; fonts[i]; i++) { FT_Done_Face(fonts[i]); }
+    for (int i = 0; fonts && fonts[i]; i++) { FT_Done_Face(fonts[i]); }
+    for (int i = 1; i < 4; i++) { if (styled_fonts[i]) FT_Done_Face(styled_fonts[i]); styled_fonts[i] = NULL; }
+    free(fonts);
+    fonts = NULL;
+    font_count = 0;
     FT_Done_FreeType(ft_lib);
 }
 
+// 0 renders grayscale coverage, 1 and 2 render LCD subpixels in RGB or BGR order.
+static int ft_lcd_mode = 0;
+
+void ft_set_lcd(int mode) {
+    ft_lcd_mode = mode;
+    if (mode) FT_Library_SetLcdFilter(ft_lib, FT_LCD_FILTER_DEFAULT);
+}
+
+// synth bit 1 emboldens and bit 2 slants the outline before rendering.
+// Colour faces load with FT_LOAD_COLOR and yield premultiplied BGRA bitmaps, never LCD or synthesized styles.
+int ft_load_and_render(FT_Face face, FT_UInt glyph_index, int synth) {
+    int color = FT_HAS_COLOR(face), lcd = ft_lcd_mode && !color;
+    if (FT_Load_Glyph(face, glyph_index, color ? FT_LOAD_COLOR : (lcd ? FT_LOAD_TARGET_LCD : FT_LOAD_DEFAULT)) != 0) return -1;
+    if (!color && (synth & 1)) FT_GlyphSlot_Embolden(face->glyph);
+    if (!color && (synth & 2)) FT_GlyphSlot_Oblique(face->glyph);
+    return FT_Render_Glyph(face->glyph, lcd ? FT_RENDER_MODE_LCD : FT_RENDER_MODE_NORMAL) != 0 ? -1 : 0;
+}
+
+// Area-averages a premultiplied BGRA bitmap down to width x height and composites it over dst, keeping dst's alpha.
+void blit_bgra_scaled(FT_Bitmap* bitmap, uint32_t* dst, int width, int height) {
+    int sw = bitmap->width, sh = bitmap->rows;
+    for (int y = 0; y < height; y++) {
+        int y0 = y * sh / height, y1 = (y + 1) * sh / height;
+        if (y1 <= y0) y1 = y0 + 1;
+        for (int x = 0; x < width; x++) {
+            int x0 = x * sw / width, x1 = (x + 1) * sw / width;
+            if (x1 <= x0) x1 = x0 + 1;
+            uint32_t sum[4] = {0, 0, 0, 0}, n = (x1 - x0) * (y1 - y0);
+            for (int sy = y0; sy < y1; sy++) {
+                unsigned char* px = bitmap->buffer + sy * bitmap->pitch + 4 * x0;
+                for (int sx = x0; sx < x1; sx++, px += 4) {
+                    for (int c = 0; c < 4; c++) sum[c] += px[c];
+                }
+            }
+            uint32_t* pixel = &dst[y * width + x];
+            uint32_t alpha = sum[3] / n, inv = 255 - alpha;
+            uint32_t b = sum[0] / n + (*pixel & 0xFF) * inv / 255;
+            uint32_t g = sum[1] / n + ((*pixel >> 8) & 0xFF) * inv / 255;
+            uint32_t r = sum[2] / n + ((*pixel >> 16) & 0xFF) * inv / 255;
+            uint32_t a = alpha + (*pixel >> 24) * inv / 255;
+            *pixel = (a > 255 ? 255 : a) << 24 | (r > 255 ? 255 : r) << 16 | (g > 255 ? 255 : g) << 8 | (b > 255 ? 255 : b);
+        }
+    }
+}
+
+int ft_bitmap_width(FT_Bitmap* bitmap) {
+    return bitmap->pixel_mode == FT_PIXEL_MODE_LCD ? bitmap->width / 3 : bitmap->width;
+}
+
+uint32_t blend_channel(uint32_t bg, uint32_t fg, uint8_t alpha, int shift) {
+    int b = (bg >> shift) & 0xFF, f = (fg >> shift) & 0xFF;
+    return (uint32_t)((b + (f - b) * alpha / 255) & 0xFF) << shift;
+}
+
+// Blends the coverage bitmap in fg over dst (width x height) with its top-left corner at (left, top).
+void blit_bitmap(FT_Bitmap* bitmap, uint32_t fg, uint32_t* dst, int width, int height, int left, int top) {
+    int lcd = bitmap->pixel_mode == FT_PIXEL_MODE_LCD, bw = ft_bitmap_width(bitmap);
+    for (int y = 0; y < (int)bitmap->rows; y++) {
+        if (top + y < 0 || top + y >= height) continue;
+        unsigned char* row = bitmap->buffer + y * bitmap->pitch;
+        for (int x = 0; x < bw; x++) {
+            if (left + x < 0 || left + x >= width) continue;
+            uint32_t* pixel = &dst[(top + y) * width + left + x];
+            if (lcd) {
+                uint8_t r = row[3 * x], g = row[3 * x + 1], b = row[3 * x + 2];
+                if (ft_lcd_mode == 2) { uint8_t t = r; r = b; b = t; }
+                if ((r | g | b) == 0) continue;
+                uint8_t alpha = r > g ? (r > b ? r : b) : (g > b ? g : b);
+                *pixel = blend_channel(*pixel, fg, alpha, 24) | blend_channel(*pixel, fg, r, 16) | blend_channel(*pixel, fg, g, 8) | blend_channel(*pixel, fg, b, 0);
+                continue;
+            }
+            // Coverage only, gamma and the real colours are applied when the mask is colorized
+            uint8_t alpha = row[x];
+            if (alpha == 0) continue;
+            *pixel = alpha == 255 ? fg : blend_channel(*pixel, fg, alpha, 24) | blend_channel(*pixel, fg, alpha, 16) | blend_channel(*pixel, fg, alpha, 8) | blend_channel(*pixel, fg, alpha, 0);
+        }
+    }
+}
+
 int render_char_to_rgba(
     const char* utf8_char,
     uint32_t bg,
     uint32_t fg,
+    int style,
+    int max_width,
+    int max_height,
     uint32_t** out_buffer,
     int* out_width,
     int* out_height,
-    int* out_baseline
+    int* out_baseline,
+    int* out_color
 ) {
-    int error, i;
+    int i, synth = style & 3;
     FT_Face face = NULL;
     uint32_t codepoint = utf8_to_codepoint(utf8_char);
-    FT_UInt glyph_index;
-    for (i = 0; fonts[i]; i++) {
+    FT_UInt glyph_index = 0;
+    if (synth && styled_fonts[synth]) {
+        glyph_index = FT_Get_Char_Index(styled_fonts[synth], codepoint);
+        if (glyph_index) {
+            face = styled_fonts[synth];
+            synth = 0;
+        }
+    }
+    for (i = 0; face == NULL && fonts && fonts[i]; i++) {
         glyph_index = FT_Get_Char_Index(fonts[i], codepoint);
         if (glyph_index) {
             face = fonts[i];
             break;
         }
     }
-    if (glyph_index == 0 || face == NULL || FT_Load_Glyph(face, glyph_index, FT_LOAD_DEFAULT) != 0) {
-        return -1;
-    }
-    // Render to 8-bit grayscale bitmap
-    if ( FT_Render_Glyph(face->glyph, FT_RENDER_MODE_NORMAL) != 0) {
+    if (glyph_index == 0 || face == NULL || ft_load_and_render(face, glyph_index, synth) != 0) {
         return -1;
     }
     FT_Bitmap* bitmap = &face->glyph->bitmap;
+    int color = bitmap->pixel_mode == FT_PIXEL_MODE_BGRA;
+    *out_color = color;
+    // Colour glyphs are scaled down to fit max_width x max_height
+    double scale = 1.0;
+    if (color && bitmap->width > 0 && bitmap->rows > 0) {
+        if ((double)max_height / bitmap->rows < scale) scale = (double)max_height / bitmap->rows;
+        if ((double)max_width / bitmap->width < scale) scale = (double)max_width / bitmap->width;
+    }
     // Allocate output buffer
-    *out_width = bitmap->width;
-    *out_height = bitmap->rows;
-    *out_baseline = face->glyph->bitmap_top;
+    *out_width = color ? (int)(bitmap->width * scale + 0.5) : ft_bitmap_width(bitmap);
+    *out_height = color ? (int)(bitmap->rows * scale + 0.5) : bitmap->rows;
+    *out_baseline = color ? (int)(face->glyph->bitmap_top * scale + 0.5) : face->glyph->bitmap_top;
     *out_buffer = (uint32_t*)malloc(*out_width * *out_height * sizeof(uint32_t));
     if (!*out_buffer) {
         return -1;
     }
-    // Render glyph with alpha blending
-    for (int y = 0; y < bitmap->rows; y++) {
-        for (int x = 0; x < bitmap->width; x++) {
-            uint8_t alpha = bitmap->buffer[y * bitmap->pitch + x];
-            uint32_t* pixel = &(*out_buffer)[y * *out_width + x];
-            if (alpha > 0) {
-                if (alpha == 255) {
-                    *pixel = fg;
-                } else {
-                    // Blend colors (approximate fast version)
-                    uint32_t bg_rb = bg& 0x00FF00FF;
-                    uint32_t bg_g = bg& 0x0000FF00;
-                    uint32_t fg_rb = fg& 0x00FF00FF;
-                    uint32_t fg_g = fg& 0x0000FF00;
-                    uint32_t rb = ((fg_rb - bg_rb) * alpha >> 8) + bg_rb;
-                    uint32_t g = ((fg_g - bg_g) * alpha >> 8) + bg_g;
-                    *pixel = (rb & 0x00FF00FF) | (g & 0x0000FF00) | (0xFF000000);
-                }
-            } else { *pixel = bg; }
-        }
+    for (i = 0; i < *out_width * *out_height; i++) {
+        (*out_buffer)[i] = bg;
+    }
+    if (color) {
+        blit_bgra_scaled(bitmap, *out_buffer, *out_width, *out_height);
+    } else {
+        blit_bitmap(bitmap, fg, *out_buffer, *out_width, *out_height, 0, 0);
     }
     return 0;
 }
@@ -105,9 +274,10 @@ int post_process_glyph(
     int y_off,
     int width,  // Returns new width
     int height,       // Fixed output height
-    uint32_t bg
+    uint32_t bg,
+    int fixed        // Keep width, otherwise narrow glyphs get half of it
 ) {
-    if (3*src_width < 2*width) { width = width/2; }
+    if (!fixed && 3*src_width < 2*width) { width = width/2; }
     int x_off = (width - src_width) / 2;
     int i, y;
     for (i=0; i<width; i++) {
@@ -137,7 +307,7 @@ int post_process_glyph(
             memcpy(
                 &dst[(y + y_off) * width],
                 &src[y * src_width-x_off],
-                src_width * sizeof(uint32_t)
+                (src_width < width ? src_width : width) * sizeof(uint32_t)
             );
         }
         for (int i=src_width+x_off; i<width; i++) {
@@ -150,10 +320,13 @@ int make_ff2_glyph(
     char* utf8_char,    // Null-terminated UTF-8 character, e.g. "A\0"
     uint32_t fg_color,    // 0xAARRGGBB
     uint32_t bg_color,    // 0xAARRGGBB
+    int style,            // 1 bold, 2 italic
     int out_width,
     int out_height,
     int out_baseline,
-    uint32_t* dst      // Pre-allocated output buffer
+    uint32_t* dst,     // Pre-allocated output buffer
+    int* out_color,    // Set when dst holds premultiplied colour instead of coverage
+    int fixed          // out_width is the cell width rather than an upper bound
 ) {
     uint32_t* buffer;
     int width, height, baseline;
@@ -161,13 +334,21 @@ int make_ff2_glyph(
         utf8_char,
         bg_color,
         fg_color,
+        style,
+        out_width,
+        out_height,
         &buffer,
         &width,
         &height,
-        &baseline
+        &baseline,
+        out_color
     );
     if (result != 0) return 0;
-    int ow = post_process_glyph(buffer, dst, width, height, out_baseline - baseline, out_width, out_height, bg_color);
+    // Keep tall glyphs inside the cell, rows beyond out_height are dropped
+    int y_off = out_baseline - baseline;
+    if (height > out_height) height = out_height;
+    if (y_off + height > out_height) y_off = out_height - height;
+    int ow = post_process_glyph(buffer, dst, width, height, y_off, out_width, out_height, bg_color, fixed);
     //printf("Glyph [%s] %d (%d,%d) -> %d\n", utf8_char, baseline, width, height, ow);
     free(buffer);
     return ow;
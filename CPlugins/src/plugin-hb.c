@@ -0,0 +1,80 @@
+// Text shaping on the primary font. Compiled in with the harfbuzz build tag, stubs otherwise.
+#ifdef XGW_HARFBUZZ
+#include <hb.h>
+
+static hb_font_t* shape_font = NULL;
+
+static int shape_setup() {
+    if (shape_font) return 1;
+    if (!ft_primary_path[0]) return 0;
+    hb_blob_t* blob = hb_blob_create_from_file(ft_primary_path);
+    hb_face_t* face = hb_face_create(blob, 0);
+    hb_blob_destroy(blob);
+    shape_font = hb_font_create(face);
+    hb_face_destroy(face);
+    return 1;
+}
+
+void shape_cleanup() {
+    if (shape_font) {
+        hb_font_destroy(shape_font);
+        shape_font = NULL;
+    }
+}
+
+uint32_t shape_char_index(uint32_t codepoint) {
+    return fonts && fonts[0] ? FT_Get_Char_Index(fonts[0], codepoint) : 0;
+}
+
+// Shapes one segment of a single direction and script, given as an ISO 15924 tag or "" to guess it.
+// Positions are returned in 26.6 pixels for the current ft_height. Returns the glyph count or -1.
+int shape_utf8(
+    const char* text,
+    int len,
+    int rtl,
+    const char* script,
+    uint32_t* glyphs,
+    uint32_t* clusters,
+    int32_t* x_advance,
+    int32_t* x_offset,
+    int32_t* y_offset,
+    int max
+) {
+    if (!fonts || !fonts[0] || !shape_setup()) return -1;
+    int ppem = fonts[0]->size->metrics.y_ppem;
+    hb_font_set_ppem(shape_font, ppem, ppem);
+    hb_font_set_scale(shape_font, ppem * 64, ppem * 64);
+    hb_buffer_t* buf = hb_buffer_create();
+    hb_buffer_add_utf8(buf, text, len, 0, len);
+    hb_buffer_set_direction(buf, rtl ? HB_DIRECTION_RTL : HB_DIRECTION_LTR);
+    if (script && script[0]) hb_buffer_set_script(buf, hb_script_from_string(script, -1));
+    hb_buffer_guess_segment_properties(buf); // Only fills in what is still unset: the script of neutral text and the language
+    hb_shape(shape_font, buf, NULL, 0);
+    unsigned int count, i;
+    hb_glyph_info_t* info = hb_buffer_get_glyph_infos(buf, &count);
+    hb_glyph_position_t* pos = hb_buffer_get_glyph_positions(buf, &count);
+    if (count > (unsigned int)max) count = max;
+    for (i = 0; i < count; i++) {
+        glyphs[i] = info[i].codepoint;
+        clusters[i] = info[i].cluster;
+        x_advance[i] = pos[i].x_advance;
+        x_offset[i] = pos[i].x_offset;
+        y_offset[i] = pos[i].y_offset;
+    }
+    hb_buffer_destroy(buf);
+    return count;
+}
+
+// Blends a glyph of the primary font over dst with its pen at (x, baseline), synthesizing bold and italic styles.
+int draw_shaped_glyph(uint32_t glyph, uint32_t fg, int style, int x, int baseline, uint32_t* dst, int width, int height) {
+    FT_Face face = fonts ? fonts[0] : NULL;
+    if (!face || ft_load_and_render(face, glyph, style & 3) != 0) return -1;
+    blit_bitmap(&face->glyph->bitmap, fg, dst, width, height, x + face->glyph->bitmap_left, baseline - face->glyph->bitmap_top);
+    return 0;
+}
+#else
+void shape_cleanup() {}
+uint32_t shape_char_index(uint32_t codepoint) { return 0; }
+int shape_utf8(const char* text, int len, int rtl, const char* script, uint32_t* glyphs, uint32_t* clusters, int32_t* x_advance, int32_t* x_offset, int32_t* y_offset, int max) { return -1; }
+int draw_shaped_glyph(uint32_t glyph, uint32_t fg, int style, int x, int baseline, uint32_t* dst, int width, int height) { return -1; }
+#endif
@@ -1,21 +1,28 @@
 #include <stdio.h>
 #include <stdlib.h>
 void panic1() { fprintf(stderr, "Fatal: missing symbols"); abort(); }
+#ifndef XGW_FONTCONFIG // Provided by libfontconfig with the fontconfig build tag
 void FcLangSetCreate(void){ panic1(); }
 void FcLangSetDestroy(void){ panic1(); }
+void FcPatternBuild(void) { panic1(); }
+void FcConfigSubstitute(void) { panic1(); }
+void FcLangSetAdd(void) { panic1(); }
+void FcPatternGetCharSet(void) { panic1(); }
+void FcPatternAddInteger(void) { panic1(); }
+void FcDefaultSubstitute(void) { panic1(); }
+void FcLangSetContains(void) { panic1(); }
+void FcFontSort(void) { panic1(); }
+void FcPatternGetInteger(void) { panic1(); }
+void FcFontSetDestroy(void) { panic1(); }
+void FcCharSetHasChar(void) { panic1(); }
+void FcPatternGetString(void) { panic1(); }
+void FcPatternGetLangSet(void) { panic1(); }
+void FcPatternDestroy(void) { panic1(); }
+#endif
 void SharpYuvInit(void){ panic1(); }
-void png_set_sRGB(void){ panic1(); }
-void png_set_swap(void){ panic1(); }
-void png_get_IHDR(void){ panic1(); }
-void png_get_channels(void){ panic1(); }
-void png_get_io_ptr(void){ panic1(); }
-void png_destroy_write_struct(void){ panic1(); }
 void g_seekable_can_seek(void){ panic1(); }
 void pcre2_pattern_info_8(void){ panic1(); }
 void pcre2_substring_number_from_name_8(void){ panic1(); }
-void png_set_packswap(void) { panic1(); }
-void png_get_rowbytes(void) { panic1(); }
-void png_get_color_type(void) { panic1(); }
 void pcre2_compile_context_free_8(void) { panic1(); }
 void pcre2_match_data_create_8(void) { panic1(); }
 void pcre2_set_newline_8(void) { panic1(); }
@@ -23,24 +30,6 @@ void pcre2_match_data_free_8(void) { panic1(); }
 void g_memory_input_stream_get_type(void) { panic1(); }
 void g_file_is_native(void) { panic1(); }
 void g_task_return_pointer(void) { panic1(); }
-void FcPatternBuild(void) { panic1(); }
-void FcConfigSubstitute(void) { panic1(); }
-void FcLangSetAdd(void) { panic1(); }
-void FcPatternGetCharSet(void) { panic1(); }
-void FcPatternAddInteger(void) { panic1(); }
-void FcDefaultSubstitute(void) { panic1(); }
-void png_destroy_read_struct(void) { panic1(); }
-void png_set_gray_to_rgb(void) { panic1(); }
-void png_get_bit_depth(void) { panic1(); }
-void png_set_palette_to_rgb(void) { panic1(); }
-void png_set_IHDR(void) { panic1(); }
-void png_read_info(void) { panic1(); }
-void png_set_read_user_transform_fn(void) { panic1(); }
-void png_write_info(void) { panic1(); }
-void png_write_end(void) { panic1(); }
-void png_create_write_struct(void) { panic1(); }
-void FT_GlyphSlot_Oblique(void) { panic1(); }
-void FcLangSetContains(void) { panic1(); }
 void pcre2_match_8(void) { panic1(); }
 void pcre2_set_bsr_8(void) { panic1(); }
 void SharpYuvConvert(void) { panic1(); }
@@ -99,71 +88,36 @@ void _ZNK5GpgME9Signature13hashAlgorithmEv(void) { panic1(); }
 void _ZNK5GpgME9Signature3keyEbb(void) { panic1(); }
 void _ZNK5GpgME9Signature3keyEv(void) { panic1(); }
 void _ZNK5GpgME9Signature6statusEv(void) { panic1(); }
-void png_read_image(void) { panic1(); }
 void g_io_error_quark(void) { panic1(); }
 void pcre2_jit_match_8(void) { panic1(); }
-void png_set_longjmp_fn(void) { panic1(); }
-void FcFontSort(void) { panic1(); }
 void g_task_set_task_data(void) { panic1(); }
-void FcPatternGetInteger(void) { panic1(); }
 void g_task_new(void) { panic1(); }
-void png_set_expand_gray_1_2_4_to_8(void) { panic1(); }
-void png_read_end(void) { panic1(); }
 void g_file_get_uri(void) { panic1(); }
-void FcFontSetDestroy(void) { panic1(); }
-void png_set_compression_level(void) { panic1(); }
 void g_task_return_error(void) { panic1(); }
-void png_set_interlace_handling(void) { panic1(); }
-void png_write_image(void) { panic1(); }
 void g_file_input_stream_query_info(void) { panic1(); }
 void g_seekable_seek(void) { panic1(); }
-void png_error(void) { panic1(); }
-void FcCharSetHasChar(void) { panic1(); }
-void png_set_tRNS_to_alpha(void) { panic1(); }
-void FT_GlyphSlot_Embolden(void) { panic1(); }
-void png_write_rows(void) { panic1(); }
 void pcre2_jit_stack_create_8(void) { panic1(); }
-void png_set_packing(void) { panic1(); }
 void pcre2_compile_context_create_8(void) { panic1(); }
 void g_task_is_valid(void) { panic1(); }
-void FcPatternGetString(void) { panic1(); }
 void pcre2_get_error_message_8(void) { panic1(); }
 void g_seekable_get_type(void) { panic1(); }
-void png_create_info_struct(void) { panic1(); }
 void pcre2_compile_8(void) { panic1(); }
-void deflateEnd(void) { panic1(); }
-void png_set_read_fn(void) { panic1(); }
 void pcre2_jit_stack_free_8(void) { panic1(); }
 void g_input_stream_get_type(void) { panic1(); }
 void g_task_set_return_on_cancel(void) { panic1(); }
-void png_set_bKGD(void) { panic1(); }
 void g_task_run_in_thread(void) { panic1(); }
 void g_file_input_stream_get_type(void) { panic1(); }
-void png_get_valid(void) { panic1(); }
-void deflate(void) { panic1(); }
 void pcre2_substring_nametable_scan_8(void) { panic1(); }
-void deflateInit_(void) { panic1(); }
-void png_set_write_fn(void) { panic1(); }
 void pcre2_jit_stack_assign_8(void) { panic1(); }
 void pcre2_code_free_8(void) { panic1(); }
-void FcPatternGetLangSet(void) { panic1(); }
-void png_init_io(void) { panic1(); }
 void g_task_return_boolean(void) { panic1(); }
-void png_get_image_height(void) { panic1(); }
 void pcre2_match_context_free_8(void) { panic1(); }
 void g_task_propagate_boolean(void) { panic1(); }
-void png_get_error_ptr(void) { panic1(); }
-void png_set_filler(void) { panic1(); }
 void g_task_return_new_error(void) { panic1(); }
-void png_read_update_info(void) { panic1(); }
-void png_get_image_width(void) { panic1(); }
 void pcre2_match_context_create_8(void) { panic1(); }
 void pcre2_jit_compile_8(void) { panic1(); }
 void g_input_stream_read(void) { panic1(); }
-void FcPatternDestroy(void) { panic1(); }
-void png_create_read_struct(void) { panic1(); }
 void g_file_read(void) { panic1(); }
-void png_set_write_user_transform_fn(void) { panic1(); }
 void pcre2_get_ovector_count_8(void) { panic1(); }
 void g_task_run_in_thread_sync(void) { panic1(); }
 void pcre2_config_8(void) { panic1(); }
@@ -171,8 +125,6 @@ void SharpYuvGetConversionMatrix(void) { panic1(); }
 void pcre2_get_ovector_pointer_8(void) { panic1(); }
 void pcre2_match_data_create_from_pattern_8(void) { panic1(); }
 void g_file_info_get_size(void) { panic1(); }
-void png_set_pHYs(void) { panic1(); }
-void png_set_iCCP(void) { panic1(); }
 void pcre2_dfa_match_8(void) { panic1(); }
 
 /*
@@ -0,0 +1,75 @@
+package xgw
+import (
+	"os/exec"
+	"sort"
+	"strings"
+	"encoding/json"
+)
+// BarDataVersion is the newest layout of the JSON published on Conf.BarAtom; older readers reject higher versions.
+const BarDataVersion = 1
+type BarBlock struct {
+	Text string `json:"text"`
+	Icon string `json:"icon,omitempty"`
+	FG string `json:"fg,omitempty"` // RRGGBB
+	BG string `json:"bg,omitempty"`
+	Actions map[string]string `json:"actions,omitempty"` // Keyed by X button number, "1" is left click, "4"/"5" the wheel
+}
+type BarData struct { Version int `json:"version"`; Blocks []BarBlock `json:"blocks"` }
+type BarHit struct { Left, Right int; Block BarBlock }
+
+// ParseBarData decodes versioned JSON; anything else is the legacy plain string and becomes a single text block.
+func ParseBarData(raw string) (ret BarData, err error) {
+	if raw = strings.TrimSpace(raw); !HasPreChar(raw, '{') {
+		if raw != "" { ret.Blocks = []BarBlock{{Text: raw}} }
+		return
+	}
+	if err = json.Unmarshal([]byte(raw), &ret); err != nil { return BarData{}, err }
+	if ret.Version < 1 || ret.Version > BarDataVersion { return BarData{}, ErrParam }
+	return
+}
+
+func PublishBarData(win Window, blocks ...BarBlock) error {
+	data, err := json.Marshal(BarData{Version: BarDataVersion, Blocks: blocks})
+	if err != nil { return err }
+	SendString(win, AtomMap[Conf.BarAtom], string(data))
+	return nil
+}
+
+func RefreshBarData(win Window) { if state, exists := WinStates[win]; exists { state.BarData = string(QueryBytes(win, Conf.BarAtom)); WinStates[win] = state } }
+func (s WindowState) Blocks() []BarBlock { data, err := ParseBarData(s.BarData); logErr(err); return data.Blocks }
+
+// CollectBarBlocks gathers the blocks of all windows in a stable order.
+func CollectBarBlocks() (ret []BarBlock) {
+	wins := make([]Window, 0, len(WinStates))
+	for win, state := range WinStates { if state.BarData != "" { wins = append(wins, win) } }
+	sort.Slice(wins, func(i, j int) bool { return wins[i] < wins[j] })
+	for _, win := range wins { ret = append(ret, WinStates[win].Blocks()...) }
+	return
+}
+
+// RenderBarBlocks draws blocks left to right from x on row y and returns the click regions.
+func RenderBarBlocks(ximg *XImage, x, y int, blocks []BarBlock) (hits []BarHit) {
+	for _, block := range blocks {
+		fg, bg, left := uint32(0xffd7afaf), uint32(0xff5f5f87), x
+		if block.FG != "" { fg = HexToUint32(strings.TrimPrefix(block.FG, "#")) }
+		if block.BG != "" { bg = HexToUint32(strings.TrimPrefix(block.BG, "#")) }
+		text := " " + block.Text + " "
+		if block.Icon != "" { text = " " + block.Icon + text }
+		if text = EllipsizeText(DefaultGlyphSize(), 0, text, ximg.Width-x, EllipsizeEnd); text == "" { break }
+		x = drawText(ximg, text, x, y, fg, bg)
+		hits = append(hits, BarHit{Left: left, Right: x, Block: block})
+	}
+	return
+}
+
+// BarAction finds the shell command bound to button on the block under x.
+func BarAction(hits []BarHit, x int, button byte) string {
+	for _, hit := range hits { if x >= hit.Left && x < hit.Right { return hit.Block.Actions[FmtInt(int(button))] } }
+	return ""
+}
+
+func runBarAction(action string) {
+	if action == "" { return }
+	cmd := exec.Command("sh", "-c", action)
+	if err := cmd.Start(); err == nil { go cmd.Wait() } else { logErr(err) }
+}
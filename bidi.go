@@ -0,0 +1,135 @@
+package xgw
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/bidi"
+)
+// Text is shaped one segment at a time: a run of one bidi direction and one script, so HarfBuzz never has to guess either for mixed text.
+// Rows are laid out like a terminal's, always in a left-to-right paragraph, whatever script the first letter belongs to.
+type textSegment struct { text string; rtl bool; script string } // script is an ISO 15924 tag, "" when only neutral characters
+
+// scriptTags maps the scripts of package unicode to the ISO 15924 tags HarfBuzz takes; others are left to its guess.
+var scriptTags = []struct { table *unicode.RangeTable; tag string }{
+	{unicode.Latin, "Latn"}, {unicode.Greek, "Grek"}, {unicode.Cyrillic, "Cyrl"}, {unicode.Armenian, "Armn"}, {unicode.Hebrew, "Hebr"},
+	{unicode.Arabic, "Arab"}, {unicode.Syriac, "Syrc"}, {unicode.Thaana, "Thaa"}, {unicode.Nko, "Nkoo"}, {unicode.Devanagari, "Deva"},
+	{unicode.Bengali, "Beng"}, {unicode.Gurmukhi, "Guru"}, {unicode.Gujarati, "Gujr"}, {unicode.Oriya, "Orya"}, {unicode.Tamil, "Taml"},
+	{unicode.Telugu, "Telu"}, {unicode.Kannada, "Knda"}, {unicode.Malayalam, "Mlym"}, {unicode.Sinhala, "Sinh"}, {unicode.Thai, "Thai"},
+	{unicode.Lao, "Laoo"}, {unicode.Tibetan, "Tibt"}, {unicode.Myanmar, "Mymr"}, {unicode.Georgian, "Geor"}, {unicode.Hangul, "Hang"},
+	{unicode.Ethiopic, "Ethi"}, {unicode.Cherokee, "Cher"}, {unicode.Khmer, "Khmr"}, {unicode.Mongolian, "Mong"}, {unicode.Hiragana, "Hira"},
+	{unicode.Katakana, "Kana"}, {unicode.Bopomofo, "Bopo"}, {unicode.Han, "Hani"},
+}
+
+func runeScript(r rune) string {
+	if r < 0x80 {
+		if r|0x20 >= 'a' && r|0x20 <= 'z' { return "Latn" }
+		return ""
+	}
+	for _, script := range scriptTags { if unicode.Is(script.table, r) { return script.tag } }
+	return ""
+}
+
+// hasRTL is a quick test for right-to-left letters, which most text has none of.
+func hasRTL(text string) bool {
+	for _, r := range text { if r >= 0x590 && r <= 0x8FF || r >= 0xFB1D && r <= 0xFDFF || r >= 0xFE70 && r <= 0xFEFF || r >= 0x10800 && r <= 0x10FFF || r >= 0x1E800 && r <= 0x1EFFF { return true } }
+	return false
+}
+
+// textSegments splits text into segments in visual order, left to right.
+func textSegments(text string) (ret []textSegment) {
+	for _, run := range bidiRuns(text) {
+		pieces := scriptPieces(run.text)
+		if run.rtl { slices.Reverse(pieces) }
+		for _, piece := range pieces { ret = append(ret, textSegment{text: piece.text, rtl: run.rtl, script: piece.script}) }
+	}
+	return
+}
+
+// bidiRuns resolves text as a left-to-right paragraph and reorders its runs visually: every stretch at an odd level, with the numbers inside it, is read right to left.
+func bidiRuns(text string) []textSegment {
+	if !hasRTL(text) { return []textSegment{{text: text}} }
+	var paragraph bidi.Paragraph
+	const lrm = "\u200e" // A strong left-to-right mark keeps the paragraph left to right
+	if _, err := paragraph.SetString(lrm + text); err != nil { return []textSegment{{text: text}} }
+	order, err := paragraph.Order()
+	if err != nil || order.NumRuns() == 0 { return []textSegment{{text: text}} }
+	var logical []textSegment
+	for i := range order.NumRuns() {
+		run := order.Run(i)
+		segment := textSegment{text: run.String(), rtl: run.Direction() == bidi.RightToLeft}
+		if i == 0 { segment.text = strings.TrimPrefix(segment.text, lrm) }
+		if segment.text == "" { continue }
+		if !segment.rtl { // x/text leaves level 2 numbers inside left-to-right runs: after right-to-left text, and Arabic digits anywhere
+			if number := leadingNumber(segment.text); number != "" && (len(logical) > 0 && logical[len(logical)-1].rtl || arabicDigits(number)) {
+				logical = append(logical, textSegment{text: number, script: "number"})
+				if segment.text = segment.text[len(number):]; segment.text == "" { continue }
+			}
+			if number := trailingNumber(segment.text); number != "" && number != segment.text && arabicDigits(number) {
+				logical = append(logical, textSegment{text: segment.text[:len(segment.text)-len(number)]})
+				segment = textSegment{text: number, script: "number"}
+			}
+		}
+		logical = append(logical, segment)
+	}
+	var visual []textSegment
+	for i := 0; i < len(logical); {
+		j := i + 1
+		if logical[i].rtl || logical[i].script == "number" { for j < len(logical) && (logical[j].rtl || logical[j].script == "number") { j++ } }
+		group := logical[i:j]
+		slices.Reverse(group)
+		for _, segment := range group { segment.script = ""; visual = append(visual, segment) }
+		i = j
+	}
+	return visual
+}
+
+// numberClass tells digits from the separators that may sit between them.
+func numberClass(r rune) (digit, separator bool) {
+	props, _ := bidi.LookupRune(r)
+	switch props.Class() {
+	case bidi.EN, bidi.AN: return true, false
+	case bidi.ES, bidi.ET, bidi.CS, bidi.NSM: return false, true
+	}
+	return false, false
+}
+
+// leadingNumber is the digits at the start of text with the separators between them, up to the first strong letter.
+func leadingNumber(text string) string {
+	end := 0
+	for i, r := range text {
+		digit, separator := numberClass(r)
+		if digit { end = i + utf8.RuneLen(r) } else if !separator { break }
+	}
+	return text[:end]
+}
+
+// trailingNumber is leadingNumber from the end of text.
+func trailingNumber(text string) string {
+	start := len(text)
+	for i := len(text); i > 0; {
+		r, size := utf8.DecodeLastRuneInString(text[:i])
+		i -= size
+		digit, separator := numberClass(r)
+		if digit { start = i } else if !separator { break }
+	}
+	return text[start:]
+}
+
+func arabicDigits(number string) bool {
+	for _, r := range number { if props, _ := bidi.LookupRune(r); props.Class() == bidi.AN { return true } }
+	return false
+}
+
+// scriptPieces splits text where the script changes; neutral characters and marks stay with the script before them, leading ones with the first script.
+func scriptPieces(text string) (ret []textSegment) {
+	start, current := 0, ""
+	for i, r := range text {
+		script := runeScript(r)
+		if script == "" || script == current { continue }
+		if current != "" { ret = append(ret, textSegment{text: text[start:i], script: current}); start = i }
+		current = script
+	}
+	return append(ret, textSegment{text: text[start:], script: current})
+}
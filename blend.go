@@ -0,0 +1,56 @@
+package xgw
+import "math"
+// Glyph masks are composed in linear light: sRGB values are decoded with Conf.Gamma (1 keeps the old naive blend), mixed by coverage and re-encoded.
+// Colours are straight ARGB, so a translucent background keeps its alpha instead of turning opaque under text.
+type gammaTable struct { gamma float64; toLinear [256]float32; fromLinear [4096]uint8 }
+var gammaLUT gammaTable
+
+func gammaTables() *gammaTable {
+	gamma := Conf.Gamma
+	if gamma <= 0 { gamma = 1 }
+	if gammaLUT.gamma != gamma {
+		gammaLUT.gamma = gamma
+		for i := range gammaLUT.toLinear { gammaLUT.toLinear[i] = float32(math.Pow(float64(i)/255, gamma)) }
+		for i := range gammaLUT.fromLinear { gammaLUT.fromLinear[i] = uint8(math.Pow(float64(i)/4095, 1/gamma)*255 + 0.5) }
+	}
+	return &gammaLUT
+}
+
+// blendPixel puts fg over bg with per-channel coverage (equal channels for grayscale, distinct ones for LCD).
+func blendPixel(bg, fg, coverage uint32) uint32 {
+	if coverage&0xFFFFFF == 0 { return bg }
+	table := gammaTables()
+	fa, ba := float32(fg>>24)/255, float32(bg>>24)/255
+	alpha := fa * float32(coverage>>16&0xFF + coverage>>8&0xFF + coverage&0xFF) / 765
+	ret := uint32((alpha + ba*(1-alpha))*255 + 0.5) << 24
+	for _, shift := range []uint{16, 8, 0} {
+		src := fa * float32(coverage>>shift&0xFF) / 255
+		weight := src + ba*(1-src)
+		if weight <= 0 { continue }
+		linear := (table.toLinear[fg>>shift&0xFF]*src + table.toLinear[bg>>shift&0xFF]*ba*(1-src)) / weight
+		ret |= uint32(table.fromLinear[int(min(linear, 1)*4095 + 0.5)]) << shift
+	}
+	return ret
+}
+
+// unpremultiply turns a premultiplied colour glyph pixel back into straight ARGB.
+func unpremultiply(px uint32) uint32 {
+	a := px >> 24
+	if a == 0 { return 0 }
+	channel := func(shift uint) uint32 { return min((px>>shift&0xFF)*255/a, 255) << shift }
+	return a<<24 | channel(16) | channel(8) | channel(0)
+}
+
+// colorize paints a mask into a fresh image with the given colours.
+func colorize(mask glyphMask, fgColor, bgColor uint32) RGBAData {
+	ret := BlankImage(mask.img.Width, mask.img.Height)
+	for i, px := range mask.img.Pix {
+		switch {
+		case mask.color: ret.Pix[i] = blendPixel(bgColor, unpremultiply(px)|0xFF000000, (px>>24)*0x010101)
+		case px&0xFFFFFF == 0: ret.Pix[i] = bgColor
+		case px&0xFFFFFF == 0xFFFFFF && fgColor>>24 == 0xFF: ret.Pix[i] = fgColor
+		default: ret.Pix[i] = blendPixel(bgColor, fgColor, px)
+		}
+	}
+	return ret
+}
@@ -11,22 +11,50 @@ func xterm256ToARGB(code int) uint32 {
 	scale := func(v int) uint32 { if v > 0 { return uint32(55 + 40*v) }; return 0}
 	return 0xFF000000 | (scale(code / 36) << 16) | (scale((code % 36) / 6) << 8) | scale(code % 6)
 }
+// xtermExtendedColor decodes the parameters after 38/48 (5;n or 2;r;g;b) and reports how many it used.
+func xtermExtendedColor(params []string) (uint32, int, bool) {
+	channel := func(s string) uint32 { return uint32(min(max(ParseInt(s), 0), 255)) }
+	switch {
+	case len(params) >= 2 && params[0] == "5": return xterm256ToARGB(ParseInt(params[1])), 2, true
+	case len(params) >= 4 && params[0] == "2": return 0xFF000000 | channel(params[1])<<16 | channel(params[2])<<8 | channel(params[3]), 4, true
+	}
+	return 0, 0, false
+}
+
 func parseXTermColor(state *MultiRowState, escapeSeq string) {
-	if len(escapeSeq) < 4 { return }
-	if escapeSeq == "\x1b[0m" { state.bgColor, state.fgColor = 0, 0xff8787af; return }
-	if parts := strings.Split(escapeSeq[2:len(escapeSeq)-1], ";"); len(parts) == 1 {
-		if code := ParseInt(parts[0]); code >= 30 && code <= 37 {
-			state.fgColor = xterm256ToARGB(code - 30)
-		} else if code >= 40 && code <= 47 {
-			state.bgColor = xterm256ToARGB(code - 40)
+	if len(escapeSeq) < 3 { return }
+	parts := strings.Split(escapeSeq[2:len(escapeSeq)-1], ";")
+	for i := 0; i < len(parts); i++ {
+		param, sub, colon := strings.Cut(parts[i], ":") // ITU T.416 form, 38:2::r:g:b
+		switch code := ParseInt(param); {
+		case code == 0: state.bgColor, state.fgColor, state.style = 0, 0xff8787af, 0
+		case code == 1: state.style |= StyleBold
+		case code == 3: state.style |= StyleItalic
+		case code == 4: state.style |= StyleUnderline
+		case code == 7: state.style |= StyleReverse
+		case code == 9: state.style |= StyleStrike
+		case code == 22: state.style &^= StyleBold
+		case code == 23: state.style &^= StyleItalic
+		case code == 24: state.style &^= StyleUnderline
+		case code == 27: state.style &^= StyleReverse
+		case code == 29: state.style &^= StyleStrike
+		case code >= 30 && code <= 37: state.fgColor = xterm256ToARGB(code - 30)
+		case code == 39: state.fgColor = 0xff8787af
+		case code >= 40 && code <= 47: state.bgColor = xterm256ToARGB(code - 40)
+		case code == 49: state.bgColor = 0
+		case code >= 90 && code <= 97: state.fgColor = xterm256ToARGB(code - 90 + 8)
+		case code >= 100 && code <= 107: state.bgColor = xterm256ToARGB(code - 100 + 8)
+		case code == 38 || code == 48:
+			params := parts[i+1:]
+			if colon {
+				if params = strings.Split(sub, ":"); len(params) == 5 && params[0] == "2" { params = append(params[:1], params[2:]...) } // Drop the colour space id
+			}
+			color, used, ok := xtermExtendedColor(params)
+			if !ok { return }
+			if code == 48 { state.bgColor = color } else { state.fgColor = color }
+			if !colon { i += used }
 		}
-	} else if len(parts) == 3 && parts[1] == "5" {
-        if code := ParseInt(parts[2]); parts[0] == "48" {
-			state.bgColor = xterm256ToARGB(code)
-		} else {
-			state.fgColor = xterm256ToARGB(code)
-		}
-    }
+	}
 }
 type TDu interface { int64 | int | string } 
 type Pair[T TDu] struct {Key string; Value T}
@@ -71,8 +99,18 @@ func DuAt[T TDu](state *DuState[T]) string {
 
 func DuWidget[T TDu](path, sortName string, widthPerc float64, Query func(string, string) *DuState[T], Run func (*DuState[T], string) string) {
 	var duState *DuState[T]
-    winWidth, cmd, cmdPos, cursors := int(float64(Width) * widthPerc), "", "xPos=" + FmtInt(15*Scale), make(map[string]int)
+    winWidth, prompt, cmdPos, cursors := int(float64(Width) * widthPerc), "", NOp(OpXPos, 15*Scale), make(map[string]int) // prompt is "/" or ":" while a command is typed
 	if winWidth < 0 { winWidth = 300 }
+	line := NewLineEditor("du", func(prefix string) []string { if duState == nil { return nil }; return completePathIn(duState.Path, prefix) })
+	showCmd := func(state *MultiRowState) { // An ambiguous completion lists the names it could take after the line, dimmed
+		state.Instructions.PushBack(cmdPos, Op{Kind: OpClear})
+		if state.Cursor = prompt != ""; !state.Cursor { return }
+		state.Instructions.PushBack(TextOp(prompt + line.Text()))
+		if names := line.CandidateNames(); len(names) > 0 {
+			state.Instructions.PushBack(Op{Kind: OpStyle, Text: "\x1b[38;5;245m"}, TextOp("  " + strings.Join(names, " ")), Op{Kind: OpStyle, Text: "\x1b[0m"})
+		}
+		state.Instructions.PushBack(NOp(OpXPos, cmdPos.N + TextCells(prompt + line.BeforeCaret())))
+	}
     init := func (state *MultiRowState) {
 		if duState != nil { cursors[duState.Path] = duState.Cursor }
 		newState := Query(path, sortName)
@@ -81,31 +119,60 @@ func DuWidget[T TDu](path, sortName string, widthPerc float64, Query func(string
 		duState.Right = state.maxRows
         if cursorBackup, exists := cursors[path]; exists { duState.Cursor = cursorBackup }
         InterpretXTerm(state, duRefresh(duState))
-		state.Instructions.PushBack("ClearRest")
+		state.Instructions.PushBack(Op{Kind: OpClearRest})
     }
+    initDnd := func (state *MultiRowState) {
+		init(state)
+		state.Drag = func() ([]string, string) {
+			if duState == nil || duState.Cursor <= 0 || duState.Cursor >= len(duState.List) { return nil, "" }
+			return []string{filepath.Join(duState.Path, duState.List[duState.Cursor].Key)}, ""
+		}
+		state.Button = func(detail byte, row, col int, double bool) int {
+			if duState == nil || len(duState.List) == 0 { return 0 }
+			oldCursor := duState.Cursor
+			switch detail {
+			case 1:
+				cursor := duState.Left + row - 1
+				if cursor >= min(duState.Right, len(duState.List)) { return 0 }
+				duState.Cursor = cursor
+				if double {
+					newPath := DuAt(duState)
+					if newPath == "" { return 0 }
+					path = newPath; init(state)
+					return 1
+				}
+			case 4: duState.Cursor = max(duState.Cursor-3, 0)
+			case 5: duState.Cursor = min(duState.Cursor+3, len(duState.List)-1)
+			default: return 0
+			}
+			InterpretXTerm(state, duUpdate(duState, oldCursor))
+			return 1
+		}
+		state.Drop = func(paths []string, text string) int {
+			if len(paths) == 0 { return 0 }
+			if path = paths[0]; !IsDir(path) { path = filepath.Dir(path) }
+			init(state)
+			return 1
+		}
+	}
     MultiRowGlyphWidget("auto-du-widget", Width - winWidth, 0, winWidth, Height - 60, func (detail byte, state *MultiRowState) (ret int) {
 		if duState == nil { return -1 }
         oldCursor := duState.Cursor
 		ret = 1
-        if cmd != "" {
-			switch detail{
-			case 9: cmd = ""; state.Instructions.PushBack(cmdPos, "Clear")
-			case 22: 
-                state.Instructions.PushBack("Backspace")
-                cmd = cmd[:len(cmd)-1]
-                if cmd == "" { state.Instructions.PushBack(cmdPos, "Clear") }
-			case 36:
-                newPath := Run(duState, cmd)
-                cmd = ""
-				if newPath == "" { return 0 }
-				path = newPath; init(state)
-            default:
-                ch := Conf.Keymap[detail]
-				if len(ch) > 4 || ch == "N/A" { return 0 }
-                cmd += ch
-                state.Instructions.PushBack("<-" + ch)
-            }
-            return
+        if prompt != "" {
+			handled, submit := line.Key(detail, state.Modifiers)
+			switch {
+			case detail == 9, !handled && detail == 22: prompt = "" // Escape, or Backspace on an empty line
+			case submit:
+				cmd := line.Submit()
+				if prompt == "/" { cmd = prompt + cmd }
+				prompt = ""; showCmd(state)
+				if newPath := Run(duState, cmd); newPath != "" { path = newPath; init(state) }
+				return
+			case !handled: return 0
+			}
+			showCmd(state)
+			return
         }
         switch detail {
         case 9, 24: return -1 // ESC, Q
@@ -119,7 +186,7 @@ func DuWidget[T TDu](path, sortName string, widthPerc float64, Query func(string
             path = newPath; init(state)
         case 57: sortName = "name"; init(state)
         case 39: sortName = "size"; init(state)
-        case 61: cmd = "/"; state.Instructions.PushBack(cmdPos, "<-/")
+        case 61: prompt = "/"; line.SetText(""); showCmd(state)
         case 40, 54, 111, 116:
 			delta := (int(detail)-113)/2
 			if Abs(delta) > 2 { delta = -2*(int(detail)-47) }
@@ -127,11 +194,9 @@ func DuWidget[T TDu](path, sortName string, widthPerc float64, Query func(string
             InterpretXTerm(state, duUpdate(duState, oldCursor))
         default: 
 			ch := Conf.Keymap[int(detail)]
-			if ch == "N/A" || len(ch) > 4 { return 0 }
-            if cmd == "" { state.Instructions.PushBack(cmdPos, "<-:") }
-            cmd += ch
-            state.Instructions.PushBack("<-" + ch)
+			if len(ch) != 1 || !IsPrintable(ch[0]) { return 0 }
+            prompt = ":"; line.SetText(ch); showCmd(state)
         }
         return
-    }, init)
+    }, initDnd)
 }
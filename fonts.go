@@ -0,0 +1,61 @@
+package xgw
+import (
+	"os"
+	"strings"
+	"path/filepath"
+)
+// FontDirs are scanned when fontconfig is unavailable (built without the fontconfig tag) or has no answer.
+var (
+	FontDirs = []string{"~/.local/share/fonts", "~/.fonts", "/usr/local/share/fonts", "/usr/share/fonts"}
+	fontFiles []string
+	fallbackFonts []string
+	fallbackReady bool
+)
+
+func IsFontPath(font string) bool { return strings.ContainsRune(font, '/') || strings.HasPrefix(font, "~") }
+func normalizeFontName(name string) string { return strings.Map(func(r rune) rune { if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' { return r }; return -1 }, strings.ToLower(name)) }
+
+func ScanFonts() []string {
+	if fontFiles != nil { return fontFiles }
+	fontFiles = []string{}
+	for _, dir := range FontDirs {
+		filepath.WalkDir(ExpandHome(dir), func(path string, entry os.DirEntry, err error) error {
+			if err != nil || entry.IsDir() { return nil }
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".ttf", ".otf", ".ttc", ".otc": fontFiles = append(fontFiles, path)
+			}
+			return nil
+		})
+	}
+	return fontFiles
+}
+
+// ResolveFont maps a path or a fontconfig-style pattern such as "DejaVu Sans Mono:bold" to a font file.
+func ResolveFont(font string) (string, error) {
+	if IsFontPath(font) { return ExpandHome(font), nil }
+	if path := fcMatch(font); path != "" { return path, nil }
+	family, style, _ := strings.Cut(font, ":")
+	key, styleKey, best, bestScore := normalizeFontName(family), normalizeFontName(strings.TrimPrefix(style, "style=")), "", -1
+	if styleKey == "" { styleKey = "regular" }
+	for _, path := range ScanFonts() {
+		name := normalizeFontName(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		if key == "" || !strings.HasPrefix(name, key) { continue }
+		rest, score := name[len(key):], 100 - len(name) + len(key)
+		if strings.Contains(rest, styleKey) { score += 100 } else if rest == "" { score += 50 }
+		if score > bestScore { best, bestScore = path, score }
+	}
+	if best == "" { return "", ErrNotFound }
+	return best, nil
+}
+
+// FallbackFonts lists the candidates probed for codepoints none of Conf.Fonts covers, best first.
+func FallbackFonts(loaded []string) []string {
+	if fallbackReady { return fallbackFonts }
+	fallbackReady, fallbackFonts = true, nil
+	skip := make(map[string]bool)
+	for _, path := range loaded { skip[path] = true }
+	candidates := ScanFonts()
+	if len(Conf.Fonts) > 0 { if sorted := fcFallbacks(Conf.Fonts[0]); len(sorted) > 0 { candidates = sorted } }
+	for _, path := range candidates { if !skip[path] { skip[path] = true; fallbackFonts = append(fallbackFonts, path) } }
+	return fallbackFonts
+}
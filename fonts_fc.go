@@ -0,0 +1,77 @@
+//go:build fontconfig
+package xgw
+/*
+#cgo pkg-config: fontconfig
+#include <fontconfig/fontconfig.h>
+#include <stdlib.h>
+#include <string.h>
+
+static FcPattern* fc_pattern(const char* name) {
+    FcPattern* pat = FcNameParse((const FcChar8*)name);
+    if (!pat) return NULL;
+    FcConfigSubstitute(NULL, pat, FcMatchPattern);
+    FcDefaultSubstitute(pat);
+    return pat;
+}
+
+static char* fc_match(const char* name) {
+    FcResult result;
+    FcChar8* file = NULL;
+    char* ret = NULL;
+    FcPattern* pat = fc_pattern(name);
+    if (!pat) return NULL;
+    FcPattern* match = FcFontMatch(NULL, pat, &result);
+    FcPatternDestroy(pat);
+    if (!match) return NULL;
+    if (FcPatternGetString(match, FC_FILE, 0, &file) == FcResultMatch) ret = strdup((char*)file);
+    FcPatternDestroy(match);
+    return ret;
+}
+
+// Newline separated files of every installed font, closest to name first.
+static char* fc_sort(const char* name) {
+    FcResult result;
+    FcPattern* pat = fc_pattern(name);
+    if (!pat) return NULL;
+    FcFontSet* set = FcFontSort(NULL, pat, FcFalse, NULL, &result);
+    FcPatternDestroy(pat);
+    if (!set) return NULL;
+    size_t len = 0, cap = 4096;
+    char* ret = (char*)malloc(cap);
+    for (int i = 0; ret && i < set->nfont; i++) {
+        FcChar8* file = NULL;
+        if (FcPatternGetString(set->fonts[i], FC_FILE, 0, &file) != FcResultMatch) continue;
+        size_t n = strlen((char*)file);
+        while (len + n + 2 > cap) { cap *= 2; char* grown = (char*)realloc(ret, cap); if (!grown) { free(ret); ret = NULL; break; } ret = grown; }
+        if (!ret) break;
+        memcpy(ret + len, file, n);
+        len += n;
+        ret[len++] = '\n';
+    }
+    if (ret) ret[len] = 0;
+    FcFontSetDestroy(set);
+    return ret;
+}
+*/
+import "C"
+import (
+	"strings"
+	"unsafe"
+)
+func fcMatch(pattern string) string {
+	name := C.CString(pattern)
+	defer C.free(unsafe.Pointer(name))
+	ret := C.fc_match(name)
+	if ret == nil { return "" }
+	defer C.free(unsafe.Pointer(ret))
+	return C.GoString(ret)
+}
+
+func fcFallbacks(pattern string) []string {
+	name := C.CString(pattern)
+	defer C.free(unsafe.Pointer(name))
+	ret := C.fc_sort(name)
+	if ret == nil { return nil }
+	defer C.free(unsafe.Pointer(ret))
+	return strings.Fields(C.GoString(ret))
+}
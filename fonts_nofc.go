@@ -0,0 +1,4 @@
+//go:build !fontconfig
+package xgw
+func fcMatch(pattern string) string { return "" }
+func fcFallbacks(pattern string) []string { return nil }
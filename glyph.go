@@ -1,13 +1,9 @@
 package xgw
-/*
-#cgo LDFLAGS: -L/output/static-libs -l:libfreetype.a -lm
-#cgo CFLAGS:  -I/output/include/freetype -I/output/include/
-#include "CPlugins/src/plugin-ff2.c"
-#include "CPlugins/src/plugin-missing.c"
-*/
-import "C"
 import (
+	"fmt"
+	"sort"
 	"unsafe"
+	"unicode/utf8"
 	"log"
 	"errors"
 	"os"
@@ -15,36 +11,59 @@ import (
 	"encoding/json"
 	"bytes"
 )
-const BarTitle, GlyphWidth, GlyphHeight, glyphBaseline = "auto-stickybar", 24, 40, 34
+const BarTitle = "auto-stickybar"
 type RGBAData struct { Pix []uint32; Width, Height, Stride int }
-type cStr struct { data []byte; Ptr *C.char }
+type GlyphSize struct { Width, Height, Baseline int }
+type glyphFace struct { size GlyphSize; style uint8 }
+const ( // SGR text attributes, combinable as a style bit set
+	StyleBold uint8 = 1 << iota
+	StyleItalic
+	StyleUnderline
+	StyleStrike
+	StyleReverse
+)
 type X11Config struct {
 	Keymap [190]string `json:"x11_keymap"`
 	XTermColors [16]uint32 `json:"xterm_colors"`
 	X11Atoms []string `json:"x11_atoms"`
 	BarAtom string `json:"bar_atom"`
-	Fonts [3]string `json:"fonts"`
+	Fonts []string `json:"fonts"` // Paths or fontconfig patterns, tried in order for each rune
+	FontFallback bool `json:"font_fallback"` // Probe installed fonts for runes none of Fonts covers
+	GlyphHeight int `json:"glyph_height"` // Pixels, 0 derives it from FontPoints and the screen DPI
+	FontPoints float64 `json:"font_points"`
+	DPI float64 `json:"dpi"` // 0 measures the screen
+	Shaping bool `json:"text_shaping"` // Only effective when built with the harfbuzz tag
+	Antialias string `json:"antialias"` // "gray", or "rgb"/"bgr" for LCD subpixel rendering
+	StyleFonts [3]string `json:"style_fonts"` // Bold, italic and bold italic faces; empty ones are synthesized
+	GlyphCacheMB int `json:"glyph_cache_mb"`
+	PaintCacheMB int `json:"paint_cache_mb"` // Painted copies of the masks, one per colour pair drawn
+	Scrollback int `json:"scrollback"` // Rows of history kept by MultiRowGlyphWidget
+	HistoryDir string `json:"history_dir"` // Where LineEditor keeps each named input's history, empty to keep none
+	Gamma float64 `json:"gamma"` // Blending gamma, 1 mixes sRGB values directly
+	AmbiguousWidth int `json:"ambiguous_width"` // Cells for East Asian Ambiguous characters: 1, 2, or 0 to measure the glyph
 }
 var (
-	glyphAtlas []uint32
-	coloredGlyphs = make(map[uint64]int)
+	GlyphWidth, GlyphHeight, glyphBaseline = 24, 40, 34
 	Conf X11Config
 	//go:embed x11.json
 	ConfData []byte
 	ErrXImg = errors.New("XImage error")
 	ErrUnknown = errors.New("unknown error")
 	ErrEmpty = errors.New("empty error")
-	ErrFull = errors.New("full error")
 	ErrNotFound = errors.New("not found err")
 	ErrLoad = errors.New("failed to load")
 	ErrLib = errors.New(".so error")
 	ErrResize = errors.New("failed to resize image")
 	ErrParam = errors.New("invalid parameters")
-	ff2Flag bool 
+	ErrGrab = errors.New("failed to grab input")
+	ff2Flag bool
+	loadedFonts []string
+	probedFonts = make(map[string]bool)
+	missingRunes = make(map[rune]bool) // Codepoints no installed font covers
+	fontCoverage = make(map[string][]uint32) // fontRanges of each fallback font probed, so no face stays open for it
 )
 func Ptr[T any, U any](b *U) *T { return (*T)(unsafe.Pointer(b)) }
 func Array[T any, U any](b *U, size int) []T { return (*(*[1<<30]T)(unsafe.Pointer(b)))[:size:size] }
-func CStr(str string) (ret cStr) { ret.data = CStrBytes(str); ret.Ptr = Ptr[C.char](&ret.data[0]); return }
 func BlankImage(w, h int) RGBAData { return RGBAData {Pix: make([]uint32, w*h), Stride: w*4, Width: w, Height: h} }
 func Crop(img RGBAData, x0, y0, w, h int) RGBAData { return RGBAData {Pix: img.Pix[(img.Stride/4)*y0+x0:], Stride: img.Stride, Width: w, Height: h} }
 func logErr(err error) bool { if err != nil { log.Printf("Err: %v", err) }; return err != nil }
@@ -60,7 +79,7 @@ func LogAndExit(cleanup func(), errs ...error) {
 }
 
 func Cleanup() {
-	if ff2Flag { C.ft_cleanup(); ff2Flag = false }
+	if ff2Flag { fontCleanup(); ff2Flag = false }
 	if xu != nil { xu.Conn().Close(); xu = nil }
 	if conn != nil { conn.Close(); conn = nil }
 }
@@ -69,30 +88,184 @@ func logAndExit(errs ...error) { LogAndExit(nil, errs...) }
 
 func initFont() { 
     logAndExit(json.NewDecoder(bytes.NewReader(ConfData)).Decode(&Conf))
-    for i, _ := range Conf.Fonts { Conf.Fonts[i] = ExpandHome(Conf.Fonts[i]) }
-	C.ft_init(CStr(Conf.Fonts[0]).Ptr, CStr(Conf.Fonts[1]).Ptr, CStr(Conf.Fonts[2]).Ptr, C.int(GlyphHeight)); ff2Flag = true 
+	ff2Flag = fontInit(GlyphHeight)
+	for _, font := range Conf.Fonts {
+		path, err := ResolveFont(font)
+		if err == nil && !fontAdd(path) { err = ErrLoad }
+		if err != nil { log.Printf("Err: font %q: %v", font, err); continue }
+		loadedFonts = append(loadedFonts, path)
+	}
+	switch Conf.Antialias {
+	case "rgb": fontSetLCD(1)
+	case "bgr": fontSetLCD(2)
+	}
+	for i, font := range Conf.StyleFonts {
+		if font == "" { continue }
+		if path, err := ResolveFont(font); err == nil { fontLoadStyle(i+1, path) } else { log.Printf("Err: font %q: %v", font, err) }
+	}
 }
 
-func GetColoredGlyph(aRune, fgColor, bgColor uint32) RGBAData {
-    cacheKey := uint64(aRune) | (uint64(fgColor*1007+bgColor)) << 32
-	if ret, exists := coloredGlyphs[cacheKey]; exists { 
-		tWidth, offset := GlyphWidth*(1+1&ret), (ret>>1)
-		return RGBAData {Pix: glyphAtlas[offset:offset+GlyphHeight*tWidth], Width: tWidth, Height: GlyphHeight, Stride: tWidth*4}
+// ensureFace walks FallbackFonts once per uncovered codepoint and appends the first face that has it to the chain; each font is opened for its coverage only the first time.
+func ensureFace(aRune uint32) (cp rune) {
+	cp, _ = utf8.DecodeRune(unsafe.Slice(Ptr[byte](&aRune), 4))
+	if !Conf.FontFallback || cp < 0x20 || cp == utf8.RuneError || missingRunes[cp] || fontHasChar(cp) { return }
+	for _, path := range FallbackFonts(loadedFonts) {
+		if probedFonts[path] { continue }
+		if fontCovers(path, cp) && fontAdd(path) { probedFonts[path] = true; loadedFonts = append(loadedFonts, path); return }
 	}
-	if cap(glyphAtlas) < len(glyphAtlas) + 2*GlyphWidth*GlyphHeight {
-		newAtlas := make([]uint32, 0, GlyphWidth*GlyphHeight*100 + cap(glyphAtlas))[:cap(glyphAtlas)]
-		copy(newAtlas, glyphAtlas)
-		glyphAtlas = newAtlas
+	missingRunes[cp] = true
+	return
+}
+
+// fontCovers tests cp against the cmap ranges of the font at path, read once per font.
+func fontCovers(path string, cp rune) bool {
+	ranges, exists := fontCoverage[path]
+	if !exists { ranges = fontRanges(path); fontCoverage[path] = ranges }
+	i := sort.Search(len(ranges)/2, func(i int) bool { return ranges[2*i+1] >= uint32(cp) })
+	return i < len(ranges)/2 && ranges[2*i] <= uint32(cp)
+}
+
+func NewGlyphSize(height int) GlyphSize { return GlyphSize{Width: height*3/5, Height: height, Baseline: height*17/20} }
+func DefaultGlyphSize() GlyphSize { return GlyphSize{Width: GlyphWidth, Height: GlyphHeight, Baseline: glyphBaseline} }
+func GetColoredGlyph(aRune, fgColor, bgColor uint32) RGBAData { return GetSizedGlyph(DefaultGlyphSize(), aRune, fgColor, bgColor) }
+
+// setGlyphSize picks the default cell from Conf, falling back to FontPoints at the measured DPI (or 96 per Scale step).
+func setGlyphSize(widthMM int) {
+	height := Conf.GlyphHeight
+	if height <= 0 {
+		dpi, points := Conf.DPI, Conf.FontPoints
+		if dpi <= 0 && widthMM > 0 { dpi = float64(Width) * 25.4 / float64(widthMM) }
+		if dpi <= 0 { dpi = float64(96 * Scale) }
+		if points <= 0 { points = 30 }
+		height = int(points * dpi / 72 + 0.5)
 	}
-	offset := len(glyphAtlas)
-	glyphAtlas = glyphAtlas[:offset+2*GlyphWidth*GlyphHeight]
-	tWidth := int(C.make_ff2_glyph(Ptr[C.char](&aRune), C.uint32_t(fgColor), C.uint32_t(bgColor), C.int(GlyphWidth*2), C.int(GlyphHeight), C.int(glyphBaseline), Ptr[C.uint32_t](&glyphAtlas[offset])))
-	if tWidth == 0 { tWidth = GlyphWidth }
-	if tWidth > GlyphWidth {
-		coloredGlyphs[cacheKey] = offset<<1 + 1
-	} else {
-		coloredGlyphs[cacheKey] = offset<<1
-		glyphAtlas = glyphAtlas[:offset+GlyphWidth*GlyphHeight]
+	if height < 8 { height = 8 }
+	size := NewGlyphSize(height)
+	GlyphWidth, GlyphHeight, glyphBaseline = size.Width, size.Height, size.Baseline
+}
+
+// GetSizedGlyph renders into a separate atlas per cell size so widgets may mix font sizes.
+func GetSizedGlyph(size GlyphSize, aRune, fgColor, bgColor uint32) RGBAData { return GetStyledGlyph(size, 0, aRune, fgColor, bgColor) }
+
+// decorate draws underline and strike-through bars across a rendered cell.
+func decorate(img RGBAData, size GlyphSize, style uint8, fgColor uint32) {
+	thickness := size.Height/20 + 1
+	bar := func(top int) { for y := top; y >= 0 && y < top+thickness && y < img.Height; y++ { for x := 0; x < img.Width; x++ { img.Pix[y*img.Stride/4+x] = fgColor } } }
+	if style&StyleUnderline != 0 { bar(size.Baseline + thickness) }
+	if style&StyleStrike != 0 { bar(size.Baseline - size.Height*3/10) }
+}
+
+// GetStyledGlyph applies SGR attributes: reverse swaps the colours, bold/italic use StyleFonts or synthesize, underline/strike are drawn over the cell.
+func GetStyledGlyph(size GlyphSize, style uint8, aRune, fgColor, bgColor uint32) RGBAData {
+	if style&StyleReverse != 0 { fgColor, bgColor, style = bgColor, fgColor, style&^StyleReverse }
+	return paintMask(styledMask(size, style, aRune), fgColor, bgColor)
+}
+
+// styledMask is the cached mask of aRune, rendered on a miss; style has no StyleReverse.
+func styledMask(size GlyphSize, style uint8, aRune uint32) glyphMask {
+	key := glyphKey{face: glyphFace{size, style}, aRune: aRune}
+	if mask, exists := lookupMask(key); exists { return mask }
+	pix := make([]uint32, 2*size.Width*size.Height)
+	cells, fixed := RuneCells(ensureFace(aRune)), true
+	if cells == 0 { cells, fixed = 2, false } // Measured: narrow bitmaps get one cell
+	tWidth, color := fontRender(size, style, aRune, size.Width*cells, fixed, pix)
+	if tWidth == 0 && fixed { tWidth = size.Width * cells } else if tWidth == 0 { tWidth = size.Width }
+	mask := glyphMask{key: key, img: RGBAData {Pix: append([]uint32{}, pix[:size.Height*tWidth]...), Width: tWidth, Height: size.Height, Stride: tWidth*4}, color: color} // Copied so the cache holds only the used pixels
+	if style&(StyleUnderline|StyleStrike) != 0 && !mask.color { decorate(mask.img, size, style, 0xFFFFFFFF) }
+	storeMask(mask)
+	return mask
+}
+
+// ShapeRun lays text out as grid cells in visual order. With HarfBuzz each cluster (ligature, base plus marks, contextual form) becomes one image spanning its cells; otherwise every rune is its own glyph.
+func ShapeRun(size GlyphSize, style uint8, text string, fgColor, bgColor uint32) []RGBAData { ret, _ := shapeRunText(size, style, text, fgColor, bgColor); return ret }
+
+// shapeRunText is ShapeRun that also returns the text each image was drawn from, so it can be redrawn on its own.
+func shapeRunText(size GlyphSize, style uint8, text string, fgColor, bgColor uint32) (ret []RGBAData, texts []string) {
+	if style&StyleReverse != 0 { fgColor, bgColor, style = bgColor, fgColor, style&^StyleReverse }
+	masks, texts := shapeRunMasks(size, style, text)
+	for _, mask := range masks { ret = append(ret, paintMask(mask, fgColor, bgColor)) }
+	return
+}
+
+// shapeRunMasks does the layout of shapeRunText without colours, which is all measuring needs; style has no StyleReverse.
+// Shaped text comes out in visual order, one bidi and script segment at a time.
+func shapeRunMasks(size GlyphSize, style uint8, text string) (ret []glyphMask, texts []string) {
+	if Conf.Shaping && len(text) > 0 {
+		shaped := true
+		for _, segment := range textSegments(text) {
+			masks, chunks, ok := shapeSegment(size, style, segment)
+			if shaped = ok; !ok { break }
+			ret, texts = append(ret, masks...), append(texts, chunks...)
+		}
+		if shaped { return }
+		ret, texts = nil, nil
+	}
+	return runeMasks(size, style, text)
+}
+
+// runeMasks gives every rune its own glyph, drawing zero-width ones over the glyph before them so the images fill the cells TextCells counts.
+func runeMasks(size GlyphSize, style uint8, text string) (ret []glyphMask, texts []string) {
+	for _, r := range text {
+		if n := len(ret); n > 0 && zeroWidth(r) { texts[n-1] += string(r); ret[n-1] = markedMask(size, style, ret[n-1], texts[n-1], r); continue }
+		ret, texts = append(ret, styledMask(size, style, StringToRune(string(r)))), append(texts, string(r))
+	}
+	return
+}
+
+// markedMask is base with the zero-width rune mark drawn centred over it, cached under the text of both.
+func markedMask(size GlyphSize, style uint8, base glyphMask, text string, mark rune) glyphMask {
+	key := glyphKey{face: glyphFace{size, style}, cluster: text}
+	if mask, exists := lookupMask(key); exists { return mask }
+	mask, over := glyphMask{key: key, img: base.img, color: base.color}, styledMask(size, style, StringToRune(string(mark))).img
+	if !base.color {
+		mask.img.Pix = append([]uint32{}, base.img.Pix...)
+		left := (base.img.Width - over.Width) / 2
+		for y := range min(base.img.Height, over.Height) {
+			for x := max(-left, 0); x < over.Width && left+x < base.img.Width; x++ {
+				dst, src := &mask.img.Pix[y*base.img.Width+left+x], over.Pix[y*over.Width+x]
+				for shift := 0; shift < 32; shift += 8 { if src>>shift&0xFF > *dst>>shift&0xFF { *dst = *dst&^(0xFF<<shift) | src&(0xFF<<shift) } }
+			}
+		}
+	}
+	storeMask(mask)
+	return mask
+}
+
+func shapeSegment(size GlyphSize, style uint8, segment textSegment) (ret []glyphMask, texts []string, ok bool) {
+	text, maxGlyphs := segment.text, len(segment.text)*2+4
+	glyphs, clusters, advances, xOffsets, yOffsets := make([]uint32, maxGlyphs), make([]uint32, maxGlyphs), make([]int32, maxGlyphs), make([]int32, maxGlyphs), make([]int32, maxGlyphs)
+	count := shapeText(size, text, segment.rtl, segment.script, glyphs, clusters, advances, xOffsets, yOffsets)
+	if count < 0 { return nil, nil, false }
+	starts := append([]uint32{}, clusters[:count]...)
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for i := 0; i < count; {
+		j, end := i, uint32(len(text))
+		for j < count && clusters[j] == clusters[i] { j++ }
+		for _, start := range starts { if start > clusters[i] { end = start; break } }
+		masks, chunks := clusterMasks(size, style, text[clusters[i]:end], glyphs[i:j], advances[i:j], xOffsets[i:j], yOffsets[i:j])
+		ret, texts = append(ret, masks...), append(texts, chunks...)
+		i = j
+	}
+	return ret, texts, true
+}
+
+func clusterMasks(size GlyphSize, style uint8, chunk string, glyphs []uint32, advances, xOffsets, yOffsets []int32) (ret []glyphMask, texts []string) {
+	runes, cells := []rune(chunk), 0
+	plain := len(runes) == 1 && len(glyphs) == 1 && xOffsets[0] == 0 && yOffsets[0] == 0 && shapeCharIndex(runes[0]) == glyphs[0]
+	for _, glyph := range glyphs { if glyph == 0 { plain = true } } // Missing in the primary font, let the fallback chain handle it
+	if plain { return runeMasks(size, style, chunk) }
+	key := glyphKey{face: glyphFace{size, style}, cluster: fmt.Sprintf("%s/%v/%v/%v", chunk, glyphs, xOffsets, yOffsets)}
+	if mask, exists := lookupMask(key); exists { return []glyphMask{mask}, []string{chunk} }
+	cells = TextCells(chunk)
+	img, total := BlankImage(cells*size.Width, size.Height), int32(0)
+	for _, advance := range advances { total += advance }
+	pen := (int32(img.Width)*64 - total) / 2
+	for i, glyph := range glyphs {
+		drawShapedGlyph(glyph, style, int((pen+xOffsets[i])>>6), size.Baseline-int(yOffsets[i]>>6), img)
+		pen += advances[i]
 	}
-	return RGBAData {Pix: glyphAtlas[offset:offset+GlyphHeight*tWidth], Width: tWidth, Height: GlyphHeight, Stride: tWidth*4}
+	decorate(img, size, style, 0xFFFFFFFF)
+	mask := glyphMask{key: key, img: img}
+	storeMask(mask)
+	return []glyphMask{mask}, []string{chunk}
 }
@@ -0,0 +1,53 @@
+//go:build !purego
+package xgw
+/*
+#cgo LDFLAGS: -L/output/static-libs -l:libfreetype.a -l:libpng16.a -l:libz.a -lm
+#cgo CFLAGS:  -I/output/include/freetype -I/output/include/
+#cgo harfbuzz CFLAGS: -DXGW_HARFBUZZ -I/output/include/harfbuzz
+#cgo harfbuzz LDFLAGS: -l:libharfbuzz.a -lstdc++
+#cgo fontconfig CFLAGS: -DXGW_FONTCONFIG
+#include "CPlugins/src/plugin-ff2.c"
+#include "CPlugins/src/plugin-hb.c"
+#include "CPlugins/src/plugin-missing.c"
+*/
+import "C"
+// The default backend renders with the static FreeType (and HarfBuzz) from CLibBuild.
+type cStr struct { data []byte; Ptr *C.char }
+func CStr(str string) (ret cStr) { ret.data = CStrBytes(str); ret.Ptr = Ptr[C.char](&ret.data[0]); return }
+
+func fontInit(height int) bool { return C.ft_init(C.int(height)) != 0 }
+func fontAdd(path string) bool { return C.ft_add_font(CStr(path).Ptr) != 0 }
+func fontLoadStyle(style int, path string) bool { return C.ft_load_style(C.int(style), CStr(path).Ptr) != 0 }
+func fontSetLCD(mode int) { C.ft_set_lcd(C.int(mode)) }
+func fontHasChar(cp rune) bool { return C.ft_has_char(C.uint32_t(cp)) != 0 }
+
+// fontRanges reads the cmap of the font at path as first/last codepoint pairs, closing it again.
+func fontRanges(path string) []uint32 {
+	buf := make([]uint32, 2048)
+	count := int(C.ft_font_ranges(CStr(path).Ptr, Ptr[C.uint32_t](&buf[0]), C.int(len(buf)/2)))
+	if count > len(buf)/2 { buf = make([]uint32, 2*count); count = int(C.ft_font_ranges(CStr(path).Ptr, Ptr[C.uint32_t](&buf[0]), C.int(count))) }
+	return buf[:2*min(max(count, 0), len(buf)/2)]
+}
+func fontCleanup() { C.shape_cleanup(); C.ft_cleanup(); clear(fontCoverage) }
+
+// fontRender draws aRune as a white-on-zero mask into pix, width pixels wide (or at most when !fixed), and returns the used width.
+func fontRender(size GlyphSize, style uint8, aRune uint32, width int, fixed bool, pix []uint32) (int, bool) {
+	var color, fix C.int
+	if fixed { fix = 1 }
+	C.ft_set_height(C.int(size.Height))
+	tWidth := int(C.make_ff2_glyph(Ptr[C.char](&aRune), 0xFFFFFFFF, 0, C.int(style), C.int(width), C.int(size.Height), C.int(size.Baseline), Ptr[C.uint32_t](&pix[0]), &color, fix))
+	return tWidth, color != 0
+}
+
+// shapeText fills the HarfBuzz glyph run of one text segment with 26.6 positions, in visual order; -1 means shaping is unavailable.
+func shapeText(size GlyphSize, text string, rtl bool, script string, glyphs, clusters []uint32, advances, xOffsets, yOffsets []int32) int {
+	var dir C.int
+	if rtl { dir = 1 }
+	C.ft_set_height(C.int(size.Height))
+	return int(C.shape_utf8(CStr(text).Ptr, C.int(len(text)), dir, CStr(script).Ptr, Ptr[C.uint32_t](&glyphs[0]), Ptr[C.uint32_t](&clusters[0]), Ptr[C.int32_t](&advances[0]), Ptr[C.int32_t](&xOffsets[0]), Ptr[C.int32_t](&yOffsets[0]), C.int(len(glyphs))))
+}
+
+func shapeCharIndex(cp rune) uint32 { return uint32(C.shape_char_index(C.uint32_t(cp))) }
+func drawShapedGlyph(glyph uint32, style uint8, x, baseline int, img RGBAData) {
+	C.draw_shaped_glyph(C.uint32_t(glyph), 0xFFFFFFFF, C.int(style), C.int(x), C.int(baseline), Ptr[C.uint32_t](&img.Pix[0]), C.int(img.Width), C.int(img.Height))
+}
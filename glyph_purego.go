@@ -0,0 +1,152 @@
+//go:build purego
+package xgw
+import (
+	"encoding/binary"
+	"os"
+	"image"
+	"unicode/utf8"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+// The purego backend parses TrueType/OpenType outlines with x/image/font/sfnt and rasterizes them in Go, so xgw builds without the CLibBuild toolchain.
+// It draws grayscale coverage only: no LCD filtering, colour emoji or HarfBuzz shaping.
+var (
+	goFonts []*sfnt.Font
+	goStyled [4]*sfnt.Font
+	goBuffer sfnt.Buffer
+)
+
+func loadGoFont(path string) *sfnt.Font {
+	data, err := os.ReadFile(path)
+	if err != nil { return nil }
+	collection, err := sfnt.ParseCollection(data)
+	if err != nil || collection.NumFonts() == 0 { return nil }
+	face, err := collection.Font(0)
+	if err != nil { return nil }
+	return face
+}
+
+func goGlyphIndex(face *sfnt.Font, cp rune) sfnt.GlyphIndex { index, err := face.GlyphIndex(&goBuffer, cp); if err != nil { return 0 }; return index }
+
+func fontInit(height int) bool { goFonts = nil; return true }
+func fontAdd(path string) bool { face := loadGoFont(path); if face != nil { goFonts = append(goFonts, face) }; return face != nil }
+func fontLoadStyle(style int, path string) bool {
+	if style < 1 || style > 3 || goStyled[style] != nil { return false }
+	goStyled[style] = loadGoFont(path)
+	return goStyled[style] != nil
+}
+func fontSetLCD(mode int) {}
+func fontHasChar(cp rune) bool { for _, face := range goFonts { if goGlyphIndex(face, cp) != 0 { return true } }; return false }
+func fontCleanup() { goFonts, goStyled = nil, [4]*sfnt.Font{}; clear(fontCoverage) }
+
+// fontRanges reads the Unicode cmap (format 12, else 4) of the first font in the file at path as first/last codepoint pairs; sfnt has no way to list it.
+func fontRanges(path string) (ret []uint32) {
+	data, err := os.ReadFile(path)
+	if err != nil { return }
+	u16 := func(at int) int { if at < 0 || at+2 > len(data) { return 0 }; return int(binary.BigEndian.Uint16(data[at:])) }
+	u32 := func(at int) int { if at < 0 || at+4 > len(data) { return 0 }; return int(binary.BigEndian.Uint32(data[at:])) }
+	add := func(first, last int) {
+		if n := len(ret); n > 0 && int(ret[n-1])+1 == first { ret[n-1] = uint32(last) } else { ret = append(ret, uint32(first), uint32(last)) }
+	}
+	font := 0
+	if string(data[:min(4, len(data))]) == "ttcf" { font = u32(12) }
+	cmap := -1
+	for i := range u16(font + 4) { if record := font + 12 + 16*i; record+4 <= len(data) && string(data[record:record+4]) == "cmap" { cmap = u32(record + 8) } }
+	if cmap < 0 { return }
+	table, format := -1, 0
+	for i := range u16(cmap + 2) {
+		record := cmap + 4 + 8*i
+		platform, encoding, sub := u16(record), u16(record+2), cmap + u32(record+4)
+		if platform != 0 && !(platform == 3 && (encoding == 1 || encoding == 10)) { continue }
+		if f := u16(sub); (f == 12 || f == 4) && f > format { table, format = sub, f }
+	}
+	switch format {
+	case 12:
+		for i := range u32(table + 12) { group := table + 16 + 12*i; add(u32(group), u32(group+4)) }
+	case 4:
+		segments := u16(table + 6) / 2
+		ends, starts, deltas, offsets := table + 14, table + 16 + 2*segments, table + 16 + 4*segments, table + 16 + 6*segments
+		for i := range segments {
+			start, end, delta, offset := u16(starts + 2*i), u16(ends + 2*i), u16(deltas + 2*i), u16(offsets + 2*i)
+			for cp := start; cp <= end && cp < 0xFFFF; cp++ {
+				glyph := (cp + delta) & 0xFFFF
+				if offset != 0 { if glyph = u16(offsets + 2*i + offset + 2*(cp-start)); glyph != 0 { glyph = (glyph + delta) & 0xFFFF } }
+				if glyph != 0 { add(cp, cp) }
+			}
+		}
+	}
+	return
+}
+
+// goOutline finds the first face with cp, preferring the dedicated style face, and returns its outline at the cell height plus the style bits left to synthesize.
+func goOutline(size GlyphSize, style uint8, cp rune) (sfnt.Segments, uint8) {
+	synth := style & (StyleBold|StyleItalic)
+	faces := goFonts
+	if face := goStyled[synth]; face != nil && goGlyphIndex(face, cp) != 0 { faces, synth = []*sfnt.Font{face}, 0 }
+	for _, face := range faces {
+		index := goGlyphIndex(face, cp)
+		if index == 0 { continue }
+		segments, err := face.LoadGlyph(&goBuffer, index, fixed.I(size.Height), nil)
+		if err != nil { return nil, 0 }
+		return append(sfnt.Segments{}, segments...), synth
+	}
+	return nil, 0
+}
+
+// goRasterize fills a coverage mask of the outline; italic is a shear of the points, bold a one pixel horizontal smear.
+func goRasterize(segments sfnt.Segments, synth uint8, baseline int) (mask *image.Alpha, left, top int) {
+	shear := float32(0)
+	if synth&StyleItalic != 0 { shear = 0.2 }
+	point := func(p fixed.Point26_6) (float32, float32) { x, y := float32(p.X)/64, float32(p.Y)/64; return x - y*shear, y }
+	bounds := segments.Bounds()
+	minY, maxY := bounds.Min.Y.Floor(), bounds.Max.Y.Ceil()
+	minX, maxX := bounds.Min.X.Floor()-int(float32(maxY)*shear), bounds.Max.X.Ceil()-int(float32(minY)*shear)+1
+	if synth&StyleBold != 0 { maxX++ }
+	w, h := maxX-minX, maxY-minY
+	if w <= 0 || h <= 0 { return nil, 0, 0 }
+	raster := vector.NewRasterizer(w, h)
+	for _, segment := range segments {
+		x0, y0 := point(segment.Args[0]); x1, y1 := point(segment.Args[1]); x2, y2 := point(segment.Args[2])
+		dx, dy := float32(-minX), float32(-minY)
+		switch segment.Op {
+		case sfnt.SegmentOpMoveTo: raster.MoveTo(x0+dx, y0+dy)
+		case sfnt.SegmentOpLineTo: raster.LineTo(x0+dx, y0+dy)
+		case sfnt.SegmentOpQuadTo: raster.QuadTo(x0+dx, y0+dy, x1+dx, y1+dy)
+		case sfnt.SegmentOpCubeTo: raster.CubeTo(x0+dx, y0+dy, x1+dx, y1+dy, x2+dx, y2+dy)
+		}
+	}
+	raster.ClosePath()
+	mask = image.NewAlpha(image.Rect(0, 0, w, h))
+	raster.Draw(mask, mask.Bounds(), image.Opaque, image.Point{})
+	if synth&StyleBold != 0 {
+		for y := 0; y < h; y++ { for x := w-1; x > 0; x-- { if prev := mask.Pix[y*mask.Stride+x-1]; prev > mask.Pix[y*mask.Stride+x] { mask.Pix[y*mask.Stride+x] = prev } } }
+	}
+	return mask, minX, baseline + minY
+}
+
+// fontRender mirrors make_ff2_glyph: the bitmap is centred in width pixels, a measured narrow glyph gets half of them.
+func fontRender(size GlyphSize, style uint8, aRune uint32, width int, fixed bool, pix []uint32) (int, bool) {
+	cp, _ := utf8.DecodeRune(Array[byte](&aRune, 4))
+	segments, synth := goOutline(size, style, cp)
+	if segments == nil { return 0, false }
+	mask, _, top := goRasterize(segments, synth, size.Baseline)
+	if mask == nil { return width, false }
+	w, h := mask.Rect.Dx(), mask.Rect.Dy()
+	if top+h > size.Height { top = size.Height - h } // Keep tall glyphs inside the cell like make_ff2_glyph
+	if top < 0 { top = 0 }
+	if !fixed && 3*w < 2*width { width /= 2 }
+	left := (width - w) / 2
+	for y := 0; y < h; y++ {
+		if top+y < 0 || top+y >= size.Height { continue }
+		for x := 0; x < w; x++ {
+			if left+x < 0 || left+x >= width { continue }
+			if c := uint32(mask.Pix[y*mask.Stride+x]); c != 0 { pix[(top+y)*width+left+x] = 0xFF000000 | c<<16 | c<<8 | c }
+		}
+	}
+	return width, false
+}
+
+func shapeText(size GlyphSize, text string, rtl bool, script string, glyphs, clusters []uint32, advances, xOffsets, yOffsets []int32) int { return -1 }
+func shapeCharIndex(cp rune) uint32 { return 0 }
+func drawShapedGlyph(glyph uint32, style uint8, x, baseline int, img RGBAData) {}
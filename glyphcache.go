@@ -0,0 +1,50 @@
+package xgw
+import "container/list"
+// Glyphs are cached as colour-independent masks: per-channel coverage for text, premultiplied ARGB for colour emoji.
+// Each colour pair a mask is drawn in is kept in a second LRU of painted copies with its own budget, which callers share and must not modify.
+type glyphKey struct { face glyphFace; aRune uint32; cluster string; fg, bg uint32 }
+type glyphMask struct { key glyphKey; img RGBAData; color bool }
+type GlyphCacheStats struct { Hits, Misses, Evictions uint64; Entries, Bytes int; PaintHits, PaintMisses, PaintEvictions uint64; PaintEntries, PaintBytes int } // Paint* count the painted copies
+type maskCache struct { lru *list.List; index map[glyphKey]*list.Element; hits, misses, evictions uint64; bytes int }
+var glyphMasks, paintedMasks = newMaskCache(), newMaskCache()
+
+func newMaskCache() *maskCache { return &maskCache{lru: list.New(), index: make(map[glyphKey]*list.Element)} }
+
+func GetGlyphCacheStats() GlyphCacheStats {
+	return GlyphCacheStats{Hits: glyphMasks.hits, Misses: glyphMasks.misses, Evictions: glyphMasks.evictions, Entries: glyphMasks.lru.Len(), Bytes: glyphMasks.bytes,
+		PaintHits: paintedMasks.hits, PaintMisses: paintedMasks.misses, PaintEvictions: paintedMasks.evictions, PaintEntries: paintedMasks.lru.Len(), PaintBytes: paintedMasks.bytes}
+}
+
+func lookupMask(key glyphKey) (glyphMask, bool) { return glyphMasks.lookup(key) }
+
+// storeMask inserts a mask and evicts the least recently used ones beyond Conf.GlyphCacheMB (default 64).
+func storeMask(mask glyphMask) { glyphMasks.store(mask, cacheLimit(Conf.GlyphCacheMB, 64)) }
+
+// paintMask colorizes mask, keeping the result within Conf.PaintCacheMB (default 16) so the next draw in the same colours is a lookup.
+func paintMask(mask glyphMask, fgColor, bgColor uint32) RGBAData {
+	key := mask.key
+	key.fg, key.bg = fgColor, bgColor
+	if painted, exists := paintedMasks.lookup(key); exists { return painted.img }
+	img := colorize(mask, fgColor, bgColor)
+	paintedMasks.store(glyphMask{key: key, img: img}, cacheLimit(Conf.PaintCacheMB, 16))
+	return img
+}
+
+func cacheLimit(mb, fallback int) int { if mb <= 0 { mb = fallback }; return mb << 20 }
+
+func (c *maskCache) lookup(key glyphKey) (glyphMask, bool) {
+	if elem, exists := c.index[key]; exists { c.hits++; c.lru.MoveToFront(elem); return elem.Value.(glyphMask), true }
+	c.misses++
+	return glyphMask{}, false
+}
+
+func (c *maskCache) store(mask glyphMask, limit int) {
+	c.index[mask.key] = c.lru.PushFront(mask)
+	c.bytes += len(mask.img.Pix) * 4
+	for c.bytes > limit && c.lru.Len() > 1 {
+		old := c.lru.Remove(c.lru.Back()).(glyphMask)
+		delete(c.index, old.key)
+		c.bytes -= len(old.img.Pix) * 4
+		c.evictions++
+	}
+}
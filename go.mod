@@ -5,4 +5,6 @@ go 1.24.5
 require (
 	github.com/BurntSushi/xgb v0.0.0-20210121224620-deaf085860bc
 	github.com/BurntSushi/xgbutil v0.0.0-20190907113008-ad855c713046
+	golang.org/x/image v0.25.0
+	golang.org/x/text v0.23.0
 )
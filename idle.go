@@ -0,0 +1,115 @@
+package xgw
+import (
+	"time"
+	"strings"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgb/screensaver"
+)
+// IdleTimer fires OnIdle once the user has been inactive for Timeout and OnActive when input resumes.
+type IdleTimer struct { Timeout time.Duration; OnIdle, OnActive func(); fired bool }
+type PasswordVerifier func(string) bool
+var ssFlag bool
+
+func IdleTime() (time.Duration, error) {
+	if !ssFlag {
+		if err := screensaver.Init(conn); err != nil { return 0, err }
+		ssFlag = true
+	}
+	reply, err := screensaver.QueryInfo(conn, xproto.Drawable(Root)).Reply()
+	if err != nil { return 0, err }
+	return time.Duration(reply.MsSinceUserInput) * time.Millisecond, nil
+}
+
+// IdleMonitor polls the MIT-SCREEN-SAVER idle counter every poll interval and drives the timers; it only returns on error.
+func IdleMonitor(poll time.Duration, timers ...*IdleTimer) error {
+	for {
+		idle, err := IdleTime()
+		if err != nil { return err }
+		for _, timer := range timers {
+			switch {
+			case !timer.fired && idle >= timer.Timeout: timer.fired = true; if timer.OnIdle != nil { timer.OnIdle() }
+			case timer.fired && idle < timer.Timeout: timer.fired = false; if timer.OnActive != nil { timer.OnActive() }
+			}
+		}
+		time.Sleep(poll)
+	}
+}
+
+const hashIterations = 600000
+
+// HashPassword encodes password for HashVerifier as "pbkdf2-sha256$iterations$salt$key", salt and key in hex.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil { return "", err }
+	key, err := pbkdf2.Key(sha256.New, password, salt, hashIterations, sha256.Size)
+	if err != nil { return "", err }
+	return fmt.Sprintf("pbkdf2-sha256$%d$%x$%x", hashIterations, salt, key), nil
+}
+
+// HashVerifier accepts the password HashPassword encoded; a malformed hash accepts nothing.
+func HashVerifier(encoded string) PasswordVerifier {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" { logErr(ErrLoad); return func(string) bool { return false } }
+	iterations := ParseInt(parts[1])
+	salt, errSalt := hex.DecodeString(parts[2])
+	want, errKey := hex.DecodeString(parts[3])
+	if iterations <= 0 || len(salt) == 0 || len(want) == 0 || logErr(errSalt) || logErr(errKey) { return func(string) bool { return false } }
+	return func(password string) bool {
+		key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+		return err == nil && subtle.ConstantTimeCompare(key, want) == 1
+	}
+}
+
+func grabInput(ximg *XImage) bool {
+	for i := 0; i < 50; i++ { // The window may not be viewable yet right after mapping
+		kb, err := xproto.GrabKeyboard(ximg.Conn, false, ximg.Win, xproto.TimeCurrentTime, xproto.GrabModeAsync, xproto.GrabModeAsync).Reply()
+		if err == nil && kb.Status == xproto.GrabStatusSuccess {
+			ptr, err := xproto.GrabPointer(ximg.Conn, false, ximg.Win, xproto.EventMaskButtonPress, xproto.GrabModeAsync, xproto.GrabModeAsync, ximg.Win, 0, xproto.TimeCurrentTime).Reply()
+			if err == nil && ptr.Status == xproto.GrabStatusSuccess { return true }
+			xproto.UngrabKeyboard(ximg.Conn, xproto.TimeCurrentTime)
+		}
+		time.Sleep(time.Second/20)
+	}
+	return false
+}
+
+// LockerWidget covers the screen, grabs keyboard and pointer and returns once verify accepts the typed password. When the grabs cannot be taken it closes at once and returns ErrGrab, rather than showing a lock that other clients still get input past.
+func LockerWidget(prompt string, verify PasswordVerifier) error {
+	password, message, failed, grabbed := "", prompt, false, false
+	UniversalWidget("auto-locker", 0, 0, Width, Height, func(ximg *XImage) (int, int) {
+		if !grabbed { return 0, 0 }
+		fg, y := uint32(0xffd7afaf), (Height-GlyphHeight)/2
+		if failed { fg = 0xffd75f5f }
+		line := message + " " + strings.Repeat("*", len(password))
+		ximg.XDraw(BlankImage(Width, GlyphHeight), 0, y)
+		drawText(ximg, line, (Width-TextWidth(DefaultGlyphSize(), 0, line))/2, y, fg, 0xff000000)
+		return 0, 0
+	}, func(detail byte, x, y int16) int { return 0 }, func(detail byte) int {
+		if !grabbed { return 0 }
+		switch detail {
+		case 9: password = ""
+		case 22: if len(password) > 0 { password = password[:len(password)-1] }
+		case 36:
+			if verify != nil && verify(password) { return -1 }
+			password, message, failed = "", "Wrong password, try again:", true
+		default:
+			if int(detail) >= len(Conf.Keymap) { return 0 }
+			ch := Conf.Keymap[detail]
+			if len(ch) != 1 || !IsPrintable(ch[0]) { return 0 }
+			password += ch
+		}
+		return 1
+	}, nil, func(ximg *XImage) {
+		RaiseWindow(ximg.Win)
+		ximg.XDraw(BlankImage(Width, Height), 0, 0)
+		if grabbed = grabInput(ximg); !grabbed { SendWmDelete(ximg.Win) }
+	})
+	if !grabbed { return ErrGrab }
+	return nil
+}
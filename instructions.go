@@ -0,0 +1,53 @@
+package xgw
+
+// OpKind selects what a glyph widget does with an Op.
+type OpKind uint8
+
+// Op is one instruction queued for MultiRowGlyphWidget or SingleRowGlyphWidget: N and M are counts or positions, Text the runes to draw or an SGR sequence.
+type Op struct { Kind OpKind; N, M int; Text string }
+
+const (
+	OpText OpKind = iota // Draw Text at the cursor
+	OpNewline // Carriage return plus line feed
+	OpIndex // Line feed, scrolling at the bottom of the region
+	OpReverseIndex // Move up, scrolling down at the top of the region
+	OpReturn
+	OpTab
+	OpSave // Cursor position and colours
+	OpRestore
+	OpReset
+	OpBackspace // Step back one cell and blank it
+	OpClear // To the end of the row
+	OpClearAll
+	OpClearRest // Rows below the cursor
+	OpXPos // Column N, from 0
+	OpYPos // Row N, from 1
+	OpStyle // Apply the SGR sequence in Text
+	OpUp
+	OpDown
+	OpRight
+	OpLeft
+	OpErase // ED: N is 0 below the cursor, 1 above it, 2 everything
+	OpEraseLine // EL: N is 1 up to the cursor, otherwise the whole row
+	OpEraseChars
+	OpInsertChars
+	OpDeleteChars
+	OpRegion // Scroll region rows N..M, M 0 for the bottom edge
+	OpInsertLines
+	OpDeleteLines
+	OpScrollUp
+	OpScrollDown
+	OpWrap // N 1 turns autowrap on
+	OpAltScreen // N 1 enters the alternate screen, 0 leaves it
+	OpSaveX // SingleRowGlyphWidget only from here on
+	OpLoadX
+	OpRaise
+	OpSetIM // Make the widget the input method window
+	OpGrab // Grab keycode N
+	OpUngrab
+	OpGrabIM // Grab the keys typed through the input method
+	OpBlocks // Draw the bar blocks of all windows, clickable for their actions
+)
+
+func TextOp(text string) Op { return Op{Kind: OpText, Text: text} }
+func NOp(kind OpKind, n int) Op { return Op{Kind: kind, N: n} }
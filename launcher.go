@@ -0,0 +1,170 @@
+package xgw
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const launcherRows = 12
+
+// FuzzyMatch scores candidate against pattern as a case-insensitive subsequence, favouring consecutive runs and word starts; positions are the matched rune indexes.
+func FuzzyMatch(pattern, candidate string) (score int, positions []int, ok bool) {
+	pat, runes := []rune(pattern), []rune(candidate)
+	if len(pat) == 0 { return 0, nil, true }
+	for i := range pat { pat[i] = unicode.ToLower(pat[i]) }
+	end, j := -1, 0
+	for i, r := range runes { if unicode.ToLower(r) == pat[j] { if j++; j == len(pat) { end = i; break } } }
+	if end < 0 { return 0, nil, false }
+	positions = make([]int, len(pat))
+	for i, j := end, len(pat)-1; j >= 0; i-- { if unicode.ToLower(runes[i]) == pat[j] { positions[j] = i; j-- } } // Walk back for the tightest match ending there
+	for k, pos := range positions {
+		score += 16
+		if pos == 0 || strings.ContainsRune(" /-_.", runes[pos-1]) || unicode.IsUpper(runes[pos]) && unicode.IsLower(runes[pos-1]) { score += 8 }
+		if k == 0 { continue }
+		if gap := pos - positions[k-1] - 1; gap == 0 { score += 12 } else { score -= min(gap, 8) }
+	}
+	return score - positions[0]/4, positions, true
+}
+
+// FuzzyFilter keeps the candidates matching pattern, best first, with the matched rune indexes of each.
+func FuzzyFilter(pattern string, candidates []string) (ret []string, marks [][]int) {
+	type match struct { text string; score int; positions []int }
+	var matches []match
+	for _, candidate := range candidates { if score, positions, ok := FuzzyMatch(pattern, candidate); ok { matches = append(matches, match{candidate, score, positions}) } }
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score { return matches[i].score > matches[j].score }
+		return len(matches[i].text) < len(matches[j].text)
+	})
+	for _, m := range matches { ret, marks = append(ret, m.text), append(marks, m.positions) }
+	return
+}
+
+// ReadLines returns the non-empty lines of r, such as candidates piped to a launcher on stdin.
+func ReadLines(r io.Reader) (ret []string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() { if line := scanner.Text(); line != "" { ret = append(ret, line) } }
+	logErr(scanner.Err())
+	return
+}
+
+// matchList is a List whose rows show the runes a fuzzy match used in the accent colour.
+type matchList struct { List; marks [][]int }
+
+func (l *matchList) Draw(ximg *XImage) {
+	fg, bg := l.colors()
+	l.fill(ximg, l.rect, bg)
+	rows := max(l.rect.H/GlyphHeight, 1)
+	l.Cursor = min(max(l.Cursor, 0), max(len(l.Items)-1, 0))
+	if l.Cursor < l.top { l.top = l.Cursor } else if l.Cursor >= l.top+rows { l.top = l.Cursor-rows+1 }
+	for i := l.top; i < len(l.Items) && i < l.top+rows; i++ {
+		x, y, rowFg, rowBg := l.rect.X, l.rect.Y+(i-l.top)*GlyphHeight, fg, bg
+		if i == l.Cursor { rowFg, rowBg = bg, fg }
+		l.fill(ximg, Rect{l.rect.X, y, l.rect.W, GlyphHeight}, rowBg)
+		marked := make(map[int]bool)
+		if i < len(l.marks) { for _, pos := range l.marks[i] { marked[pos] = true } }
+		for pos, r := range []rune(EllipsizeText(DefaultGlyphSize(), 0, l.Items[i], l.rect.W, EllipsizeEnd)) {
+			color := rowFg
+			if marked[pos] { color = 0xffffaf5f }
+			x = drawText(ximg, string(r), x, y, color, rowBg)
+		}
+	}
+}
+
+// Launcher shows candidates in a dmenu-like strip at the top of the screen, narrowing them by fuzzy match as the user types. It returns the highlighted line, the typed text when nothing matches, or "" when dismissed with Escape.
+func Launcher(prompt string, candidates []string) (ret string) {
+	list := &matchList{}
+	list.SetItems(candidates)
+	input := NewInput(prompt, "", nil, func(text string) int {
+		if ret = text; list.Cursor < len(list.Items) { ret = list.Items[list.Cursor] }
+		return -1
+	})
+	input.OnChange = func(text string) {
+		list.Items, list.marks = FuzzyFilter(text, candidates)
+		list.Cursor, list.top = 0, 0
+		list.Invalidate()
+	}
+	rows := min(len(candidates), launcherRows)
+	PanelWidget("auto-launcher", 0, 0, Width, (rows+1)*GlyphHeight, NewStack(false, input, list), func(detail byte, panel *Panel) int {
+		switch detail {
+		case 9: ret = ""; return -1 // Escape
+		case 111: list.Cursor = max(list.Cursor-1, 0)
+		case 116: list.Cursor = min(list.Cursor+1, len(list.Items)-1)
+		default: return 0
+		}
+		list.Invalidate()
+		return 1
+	}, nil)
+	return
+}
+
+// PathExecutables lists the distinct names of the executable files in $PATH, sorted.
+func PathExecutables() (ret []string) {
+	seen := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil { continue }
+		for _, entry := range entries {
+			name := entry.Name()
+			if seen[name] { continue }
+			if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() && info.Mode()&0111 != 0 { seen[name] = true; ret = append(ret, name) }
+		}
+	}
+	sort.Strings(ret)
+	return
+}
+
+// DesktopEntries maps the Name of each visible application .desktop file in the XDG data directories to its Exec command line, field codes removed.
+func DesktopEntries() map[string][]string {
+	ret, dirs := make(map[string][]string), []string{ExpandHome("~/.local/share")}
+	if home := os.Getenv("XDG_DATA_HOME"); home != "" { dirs[0] = home }
+	dataDirs := os.Getenv("XDG_DATA_DIRS")
+	if dataDirs == "" { dataDirs = "/usr/local/share:/usr/share" }
+	for _, dir := range append(dirs, filepath.SplitList(dataDirs)...) {
+		files, _ := filepath.Glob(filepath.Join(dir, "applications", "*.desktop"))
+		for _, file := range files {
+			name, command := parseDesktopEntry(file)
+			if _, exists := ret[name]; name != "" && len(command) > 0 && !exists { ret[name] = command } // Earlier directories take precedence
+		}
+	}
+	return ret
+}
+
+func parseDesktopEntry(file string) (name string, command []string) {
+	data, err := os.ReadFile(file)
+	if err != nil { return }
+	inEntry := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") { inEntry = line == "[Desktop Entry]"; continue }
+		key, value, found := strings.Cut(line, "=")
+		if !inEntry || !found { continue }
+		switch key {
+		case "Name": name = value
+		case "Exec": for _, field := range strings.Fields(value) { if !strings.HasPrefix(field, "%") { command = append(command, strings.Trim(field, `"`)) } }
+		case "NoDisplay", "Hidden": if value == "true" { return "", nil }
+		case "Type": if value != "Application" { return "", nil }
+		}
+	}
+	return
+}
+
+// AppLauncher offers desktop applications and $PATH executables in a Launcher and starts the chosen one, replacing an external dmenu_run.
+func AppLauncher() error {
+	apps := DesktopEntries()
+	candidates := make([]string, 0, len(apps))
+	for name := range apps { candidates = append(candidates, name) }
+	sort.Strings(candidates)
+	choice := Launcher("run: ", append(candidates, PathExecutables()...))
+	command, isApp := apps[choice]
+	if !isApp { command = strings.Fields(choice) }
+	if len(command) == 0 { return nil }
+	cmd := exec.Command(command[0], command[1:]...)
+	if err := cmd.Start(); err != nil { return err }
+	go cmd.Wait()
+	return nil
+}
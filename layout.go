@@ -0,0 +1,61 @@
+package xgw
+import "strings"
+// Layout measures the glyphs ShapeRun lays out, so widths match what drawText and OpText put on screen, double-width and shaped clusters included.
+type Ellipsis int
+const (
+	EllipsizeEnd Ellipsis = iota
+	EllipsizeMiddle
+)
+const ellipsis = "…"
+
+// TextWidth adds up the widths of the cached masks ShapeRun would paint, so measuring never colorizes.
+func TextWidth(size GlyphSize, style uint8, text string) (ret int) {
+	masks, _ := shapeRunMasks(size, style&^StyleReverse, text)
+	for _, mask := range masks { ret += mask.img.Width }
+	return
+}
+
+// fitRunes returns the longest prefix length of runes that still fits maxWidth once suffix is appended.
+func fitRunes(size GlyphSize, style uint8, runes []rune, maxWidth int, suffix string) int {
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if TextWidth(size, style, string(runes[:mid]) + suffix) <= maxWidth { lo = mid } else { hi = mid - 1 }
+	}
+	return lo
+}
+
+// WrapText breaks text into lines no wider than maxWidth at spaces and newlines; words longer than a line are split between runes.
+func WrapText(size GlyphSize, style uint8, text string, maxWidth int) (lines []string) {
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" { candidate = line + " " + word }
+			if TextWidth(size, style, candidate) <= maxWidth { line = candidate; continue }
+			if line != "" { lines = append(lines, line) }
+			for line = word; TextWidth(size, style, line) > maxWidth; {
+				runes := []rune(line)
+				n := max(fitRunes(size, style, runes, maxWidth, ""), 1)
+				lines, line = append(lines, string(runes[:n])), string(runes[n:])
+			}
+		}
+		lines = append(lines, line)
+	}
+	return
+}
+
+// EllipsizeText shortens text to maxWidth by replacing its end or middle with an ellipsis; it returns "" when not even the ellipsis fits.
+func EllipsizeText(size GlyphSize, style uint8, text string, maxWidth int, mode Ellipsis) string {
+	if TextWidth(size, style, text) <= maxWidth { return text }
+	if TextWidth(size, style, ellipsis) > maxWidth { return "" }
+	runes := []rune(text)
+	if mode == EllipsizeEnd { return string(runes[:fitRunes(size, style, runes, maxWidth, ellipsis)]) + ellipsis }
+	middle := func(keep int) string { return string(runes[:(keep+1)/2]) + ellipsis + string(runes[len(runes)-keep/2:]) }
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if TextWidth(size, style, middle(mid)) <= maxWidth { lo = mid } else { hi = mid - 1 }
+	}
+	return middle(lo)
+}
@@ -0,0 +1,157 @@
+package xgw
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+const historyLimit = 500
+
+// LineEditor is a readline-style line for text inputs: caret movement, word and line kills, history kept per name, and tab completion.
+type LineEditor struct {
+	line []rune
+	Caret int // Runes before the caret
+	History []string // Oldest first
+	Complete func(prefix string) []string // Candidates replacing the text before the caret
+	Candidates []string // Left by an ambiguous completion for the widget to show until the next key
+	name, draft string
+	recall int // History index shown, len(History) for the draft
+}
+
+// NewLineEditor loads the history saved under name in Conf.HistoryDir; an empty name keeps it in memory.
+func NewLineEditor(name string, complete func(string) []string) *LineEditor {
+	ret := &LineEditor{name: name, Complete: complete}
+	if path := ret.historyPath(); path != "" {
+		if data, err := os.ReadFile(path); err == nil { ret.History = strings.FieldsFunc(string(data), func(r rune) bool { return r == '\n' }) }
+	}
+	ret.recall = len(ret.History)
+	return ret
+}
+
+func (e *LineEditor) historyPath() string {
+	if e.name == "" || Conf.HistoryDir == "" { return "" }
+	return filepath.Join(ExpandHome(Conf.HistoryDir), e.name)
+}
+
+func (e *LineEditor) Text() string { return string(e.line) }
+func (e *LineEditor) BeforeCaret() string { return string(e.line[:e.Caret]) }
+func (e *LineEditor) SetText(text string) { e.line = []rune(text); e.Caret = len(e.line); e.Candidates = nil }
+
+func (e *LineEditor) insert(text string) {
+	runes := []rune(text)
+	e.line = append(e.line[:e.Caret], append(runes, e.line[e.Caret:]...)...)
+	e.Caret += len(runes)
+}
+
+func (e *LineEditor) kill(from, to int) { e.line = append(e.line[:from], e.line[to:]...); e.Caret = from }
+
+func (e *LineEditor) wordStart() (i int) {
+	for i = e.Caret; i > 0 && unicode.IsSpace(e.line[i-1]); i-- {}
+	for ; i > 0 && !unicode.IsSpace(e.line[i-1]); i-- {}
+	return
+}
+
+func (e *LineEditor) show(index int) {
+	if e.recall == len(e.History) { e.draft = e.Text() }
+	e.recall = index
+	if index == len(e.History) { e.SetText(e.draft) } else { e.SetText(e.History[index]) }
+}
+
+// Submit records the line in the history and returns it.
+func (e *LineEditor) Submit() string {
+	text := e.Text()
+	if text != "" && (len(e.History) == 0 || e.History[len(e.History)-1] != text) {
+		if e.History = append(e.History, text); len(e.History) > historyLimit { e.History = e.History[len(e.History)-historyLimit:] }
+		if path := e.historyPath(); path != "" {
+			logErr(os.MkdirAll(filepath.Dir(path), 0755))
+			logErr(os.WriteFile(path, []byte(strings.Join(e.History, "\n") + "\n"), 0644))
+		}
+	}
+	e.recall, e.draft = len(e.History), ""
+	return text
+}
+
+// Key edits the line for a widget key code and its X modifier mask. It reports whether the key was used and whether it was Return.
+func (e *LineEditor) Key(detail byte, modifiers uint16) (handled, submit bool) {
+	code := detail
+	if detail >= 128 { code = detail - 128 }
+	e.Candidates = nil
+	if modifiers&xproto.ModMaskControl != 0 {
+		switch code {
+		case 38: e.Caret = 0 // A
+		case 26: e.Caret = len(e.line) // E
+		case 45: e.kill(e.Caret, len(e.line)) // K
+		case 30: e.kill(0, e.Caret) // U
+		case 25: e.kill(e.wordStart(), e.Caret) // W
+		default: return false, false
+		}
+		return true, false
+	}
+	switch code {
+	case 36: return true, true
+	case 113: e.Caret = max(e.Caret-1, 0)
+	case 114: e.Caret = min(e.Caret+1, len(e.line))
+	case 110: e.Caret = 0 // Home
+	case 115: e.Caret = len(e.line) // End
+	case 22: if e.Caret == 0 { return false, false }; e.kill(e.Caret-1, e.Caret)
+	case 119: if e.Caret < len(e.line) { e.kill(e.Caret, e.Caret+1) } // Delete
+	case 111: if e.recall == 0 { return false, false }; e.show(e.recall-1) // Past either end of the history Up and Down are left to the widget
+	case 116: if e.recall == len(e.History) { return false, false }; e.show(e.recall+1)
+	case 23: if e.Complete == nil || modifiers&xproto.ModMaskShift != 0 { return false, false }; e.complete() // Otherwise Tab is left to focus cycling
+	default:
+		if int(detail) >= len(Conf.Keymap) { return false, false }
+		ch := Conf.Keymap[detail]
+		if len(ch) != 1 || !IsPrintable(ch[0]) { return false, false }
+		e.insert(ch)
+	}
+	return true, false
+}
+
+func (e *LineEditor) complete() {
+	if e.Complete == nil { return }
+	prefix := e.BeforeCaret()
+	candidates := e.Complete(prefix)
+	if len(candidates) == 0 { return }
+	common := candidates[0]
+	for _, candidate := range candidates[1:] { for !strings.HasPrefix(candidate, common) { common = common[:len(common)-1] } }
+	for !utf8.ValidString(common) { common = common[:len(common)-1] } // Cut back to a rune boundary
+	if len(common) > len(prefix) || len(candidates) == 1 {
+		rest := string(e.line[e.Caret:])
+		e.SetText(common)
+		e.insert(rest)
+		e.Caret -= len([]rune(rest))
+	}
+	if e.Candidates = nil; len(candidates) > 1 { e.Candidates = candidates }
+}
+
+// CandidateNames shortens Candidates to what each adds: the last word, or the last path element with its slash.
+func (e *LineEditor) CandidateNames() []string {
+	names := make([]string, len(e.Candidates))
+	for i, candidate := range e.Candidates { names[i] = candidate[strings.LastIndexAny(strings.TrimSuffix(candidate, "/"), " /")+1:] }
+	return names
+}
+
+// CompletePath completes the last word of prefix as a file path, directories ending in a slash.
+func CompletePath(prefix string) []string { return completePathIn(".", prefix) }
+
+// completePathIn is CompletePath with relative paths taken from cwd.
+func completePathIn(cwd, prefix string) (ret []string) {
+	head, word := "", prefix
+	if i := strings.LastIndexAny(prefix, " \t"); i >= 0 { head, word = prefix[:i+1], prefix[i+1:] }
+	dir, base := filepath.Split(word)
+	parent := ExpandHome(dir)
+	if !filepath.IsAbs(parent) { parent = filepath.Join(cwd, parent) }
+	entries, err := os.ReadDir(parent)
+	if err != nil { return }
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (base == "" && strings.HasPrefix(name, ".")) { continue }
+		if entry.IsDir() || IsDir(filepath.Join(parent, name)) { name += "/" }
+		ret = append(ret, head + dir + name)
+	}
+	return
+}
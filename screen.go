@@ -0,0 +1,105 @@
+package xgw
+import "strings"
+// screenCell is one grid cell of a MultiRowGlyphWidget: text is the rune or shaped cluster drawn from it, cont marks cells a wide glyph spills into.
+type screenCell struct { text string; fg, bg uint32; style uint8; cont bool }
+
+// screenModel mirrors what MultiRowGlyphWidget drew so it can scroll back through history and redraw after the alternate screen.
+type screenModel struct { rows, history, main [][]screenCell; cols, offset int }
+
+func newScreenModel(rows, cols int) *screenModel { return &screenModel{rows: blankRows(rows, cols), cols: cols} }
+func blankRows(rows, cols int) (ret [][]screenCell) { for range rows { ret = append(ret, make([]screenCell, cols)) }; return }
+
+func (m *screenModel) valid(row int) bool { return row >= 0 && row < len(m.rows) }
+
+// put stores a glyph spanning cells columns from col.
+func (m *screenModel) put(row, col, cells int, cell screenCell) {
+	if !m.valid(row) || col < 0 || col >= m.cols { return }
+	m.rows[row][col] = cell
+	for i := col+1; i < min(col+cells, m.cols); i++ { m.rows[row][i] = screenCell{cont: true} }
+}
+
+func (m *screenModel) erase(row, col, count int) {
+	if !m.valid(row) { return }
+	for i := max(col, 0); i < min(col+count, m.cols); i++ { m.rows[row][i] = screenCell{} }
+}
+
+// scroll moves rows top..bottom (0-based) up by n, or down when n is negative; rows leaving the top of the main screen go to history.
+func (m *screenModel) scroll(top, bottom, n int) {
+	if top < 0 || bottom >= len(m.rows) || top > bottom || n == 0 { return }
+	height := bottom - top + 1
+	if n > 0 {
+		n = min(n, height)
+		if top == 0 && m.main == nil { m.keep(m.rows[:n]) }
+		copy(m.rows[top:], m.rows[top+n:bottom+1])
+		copy(m.rows[bottom-n+1:], blankRows(n, m.cols))
+	} else {
+		n = min(-n, height)
+		copy(m.rows[top+n:bottom+1], m.rows[top:bottom+1-n])
+		copy(m.rows[top:], blankRows(n, m.cols))
+	}
+}
+
+func (m *screenModel) keep(rows [][]screenCell) {
+	limit := max(Conf.Scrollback, 0)
+	for _, row := range rows { m.history = append(m.history, row) }
+	if len(m.history) > limit { m.history = append([][]screenCell{}, m.history[len(m.history)-limit:]...) }
+}
+
+// shift inserts n blank cells at col (deletes them when n is negative), moving the rest of the row.
+func (m *screenModel) shift(row, col, n int) {
+	if !m.valid(row) || col < 0 || col >= m.cols { return }
+	line := m.rows[row]
+	if n > 0 {
+		n = min(n, m.cols-col)
+		copy(line[col+n:], line[col:])
+		for i := col; i < col+n; i++ { line[i] = screenCell{} }
+	} else {
+		n = min(-n, m.cols-col)
+		copy(line[col:], line[col+n:])
+		for i := m.cols-n; i < m.cols; i++ { line[i] = screenCell{} }
+	}
+}
+
+// alternate switches to a blank alternate screen and back; history is frozen meanwhile.
+func (m *screenModel) alternate(on bool) {
+	switch {
+	case on && m.main == nil: m.main, m.rows = m.rows, blankRows(len(m.rows), m.cols)
+	case !on && m.main != nil: m.rows, m.main = m.main, nil
+	}
+}
+
+func (m *screenModel) clear() { m.rows = blankRows(len(m.rows), m.cols) }
+
+// page scrolls the view delta rows back into history (forward when negative) and reports whether it moved.
+func (m *screenModel) page(delta int) bool {
+	offset := min(max(m.offset+delta, 0), len(m.history))
+	moved := offset != m.offset
+	m.offset = offset
+	return moved
+}
+
+// view returns the rows currently on display.
+func (m *screenModel) view() [][]screenCell {
+	if m.offset == 0 { return m.rows }
+	ret := append([][]screenCell{}, m.history[len(m.history)-m.offset:]...)
+	return append(ret, m.rows[:max(len(m.rows)-m.offset, 0)]...)[:len(m.rows)]
+}
+
+// text returns the cells of view() from one {row, col} to another inclusive, a line per row with trailing blanks dropped.
+func (m *screenModel) text(from, to [2]int) string {
+	rows, lines := m.view(), []string{}
+	for row := max(from[0], 0); row <= to[0] && row < len(rows); row++ {
+		first, last, line := 0, m.cols-1, strings.Builder{}
+		if row == from[0] { first = from[1] }
+		if row == to[0] { last = min(to[1], m.cols-1) }
+		for _, cell := range rows[row][max(first, 0):last+1] {
+			switch {
+			case cell.cont:
+			case cell.text == "": line.WriteByte(' ')
+			default: line.WriteString(cell.text)
+			}
+		}
+		lines = append(lines, strings.TrimRight(line.String(), " "))
+	}
+	return strings.Join(lines, "\n")
+}
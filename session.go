@@ -0,0 +1,109 @@
+package xgw
+import (
+	"os"
+	"time"
+	"bytes"
+	"strings"
+	"os/exec"
+	"encoding/json"
+	"github.com/BurntSushi/xgb/xproto"
+)
+type SessionWindow struct {
+	Class string `json:"class"`
+	Title string `json:"title"`
+	Command []string `json:"command"`
+	Desktop int `json:"desktop"`
+	X int `json:"x"`
+	Y int `json:"y"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+	Sticky bool `json:"sticky"`
+}
+type Session struct { Windows []SessionWindow `json:"windows"`; pending []SessionWindow; placed map[Window]bool }
+
+func GetClass(win Window) string { if parts := bytes.Split(QueryBytes(win, "WM_CLASS"), []byte{0}); len(parts) >= 2 { return string(parts[1]) }; return "" }
+func GetDesktop(win Window) (int, bool) { if reply, err := xproto.GetProperty(conn, false, win, AtomMap["_NET_WM_DESKTOP"], AtomMap["CARDINAL"], 0, 1).Reply(); err == nil && len(reply.Value) >= 4 { return int(*Ptr[uint32](&reply.Value[0])), true }; return DeskID, false }
+// SetDesktop records desk on win and moves it there: it joins DesktopWins when desk is the current one, otherwise it is unmapped.
+func SetDesktop(win Window, desk int) {
+	data32 := uint32(desk)
+	SendBytes(win, AtomMap["_NET_WM_DESKTOP"], AtomMap["CARDINAL"], 32, Array[byte](&data32, 4))
+	StickyWins, DesktopWins = RemoveElement(StickyWins, win), RemoveElement(DesktopWins, win)
+	if desk == DeskID { DesktopWins = append(DesktopWins, win) } else { Unmap(win) }
+}
+
+func GetCommand(win Window) []string {
+	pid := GetWindowPID(win)
+	if pid == 0 { return nil }
+	data, err := os.ReadFile("/proc/" + FmtInt(int(pid)) + "/cmdline")
+	if err != nil || len(data) == 0 { return nil }
+	return strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+}
+
+func SnapshotSession() (ret Session) {
+	for win, state := range WinStates {
+		class, title := GetClass(win), GetTitle(win)
+		desk, hasDesk := GetDesktop(win)
+		if class == "" || strings.HasPrefix(title, "auto-") || (!state.Mapped && !hasDesk) { continue }
+		x, y, w, h := GetGeometry(win)
+		sticky := false
+		for _, sub := range StickyWins { if sub == win { sticky = true } }
+		ret.Windows = append(ret.Windows, SessionWindow{Class: class, Title: title, Command: GetCommand(win), Desktop: desk, X: x, Y: y, Width: w, Height: h, Sticky: sticky})
+	}
+	return
+}
+
+func SaveSession(file string) error {
+	data, err := json.MarshalIndent(SnapshotSession(), "", "  ")
+	if err != nil { return err }
+	return os.WriteFile(ExpandHome(file), data, 0644)
+}
+
+func LoadSession(file string) (*Session, error) {
+	data, err := os.ReadFile(ExpandHome(file))
+	if err != nil { return nil, err }
+	ret := &Session{placed: make(map[Window]bool)}
+	if err = json.Unmarshal(data, ret); err != nil { return nil, err }
+	ret.pending = append(ret.pending, ret.Windows...)
+	return ret, nil
+}
+
+// Place moves win to the geometry of the first pending entry of its class, preferring an exact title match; the WM should call it for every new window while restoring.
+func (s *Session) Place(win Window) bool {
+	if s.placed[win] { return false }
+	class, title, match := GetClass(win), GetTitle(win), -1
+	for i, entry := range s.pending {
+		if entry.Class != class { continue }
+		if match < 0 || entry.Title == title { match = i }
+		if entry.Title == title { break }
+	}
+	if match < 0 { return false }
+	entry := s.pending[match]
+	s.pending, s.placed[win] = append(s.pending[:match], s.pending[match+1:]...), true
+	ResizeWindow(win, entry.X, entry.Y, entry.Width, entry.Height)
+	SetDesktop(win, entry.Desktop)
+	if entry.Sticky { StickyWins = append(RemoveElement(StickyWins, win), win); DesktopWins = RemoveElement(DesktopWins, win); Map(win) }
+	return true
+}
+
+// Launch places windows that already exist and starts the command of each remaining entry, so two saved terminals come back as two.
+func (s *Session) Launch() {
+	QueryTree(Root, func(win Window) { s.Place(win) })
+	for _, entry := range s.pending {
+		if len(entry.Command) == 0 { continue }
+		cmd := exec.Command(entry.Command[0], entry.Command[1:]...)
+		logErr(cmd.Start())
+		go cmd.Wait()
+	}
+}
+
+func (s *Session) Pending() int { return len(s.pending) }
+
+// RestoreSession relaunches missing applications and places windows as they appear, giving up after timeout.
+func RestoreSession(file string, timeout time.Duration) error {
+	s, err := LoadSession(file)
+	if err != nil { return err }
+	s.Launch()
+	for deadline := time.Now().Add(timeout); s.Pending() > 0 && time.Now().Before(deadline); time.Sleep(time.Second/4) { QueryTree(Root, func(win Window) { s.Place(win) }) }
+	if s.Pending() > 0 { return ErrNotFound }
+	return nil
+}
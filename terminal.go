@@ -0,0 +1,142 @@
+package xgw
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+	"github.com/BurntSushi/xgb/xproto"
+)
+// Terminal is a child process on a pseudo-terminal; TerminalWidget feeds its output through InterpretXTerm.
+type Terminal struct { PTY *os.File; Cmd *exec.Cmd; mu sync.Mutex; output []byte; closed bool }
+
+// termKeys are the xterm input sequences of the named keys in Conf.Keymap.
+var termKeys = map[string]string{"Escape": "\x1b", "Backspace": "\x7f", "Tab": "\t", "Return": "\r"}
+
+// termCodes map raw X keycodes of cursor, editing and function keys to the final byte of CSI 1;<mod> X, or the number of CSI <n>;<mod> ~.
+var termCodes = map[byte]string{
+	111: "A", 116: "B", 114: "C", 113: "D", 110: "H", 115: "F", 118: "2", 119: "3", 112: "5", 117: "6",
+	67: "P", 68: "Q", 69: "R", 70: "S", 71: "15", 72: "17", 73: "18", 74: "19", 75: "20", 76: "21", 95: "23", 96: "24",
+}
+
+// termCodeBytes builds the xterm sequence of a termCodes key, with the modifier parameter 1 + Shift + 2*Alt + 4*Control when any is held.
+func termCodeBytes(code string, modifiers uint16) []byte {
+	mod := 1
+	if modifiers&xproto.ModMaskShift != 0 { mod += 1 }
+	if modifiers&xproto.ModMask1 != 0 { mod += 2 }
+	if modifiers&xproto.ModMaskControl != 0 { mod += 4 }
+	final, params := code, ""
+	if code[0] >= '0' && code[0] <= '9' { final, params = "~", code }
+	switch {
+	case mod > 1 && params == "": params = "1;" + FmtInt(mod)
+	case mod > 1: params += ";" + FmtInt(mod)
+	case strings.Contains("PQRS", final): return []byte("\x1bO" + final) // Plain F1-F4 use SS3
+	}
+	return []byte("\x1b[" + params + final)
+}
+
+func ioctl(fd uintptr, request uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(request), uintptr(arg)); errno != 0 { return errno }
+	return nil
+}
+
+func OpenPTY() (master, slave *os.File, err error) {
+	if master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0); err != nil { return }
+	var unlock, index uint32
+	if err = ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err == nil { err = ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&index)) }
+	if err == nil { slave, err = os.OpenFile("/dev/pts/" + FmtInt(int(index)), os.O_RDWR|syscall.O_NOCTTY, 0) }
+	if err != nil { master.Close(); master = nil }
+	return
+}
+
+// StartTerminal runs argv (the login shell when empty) in dir as the session leader of a new pseudo-terminal.
+func StartTerminal(dir string, argv []string, cols, rows int) (*Terminal, error) {
+	if len(argv) == 0 { argv = []string{os.Getenv("SHELL")}; if argv[0] == "" { argv[0] = "/bin/sh" } }
+	master, slave, err := OpenPTY()
+	if err != nil { return nil, err }
+	defer slave.Close()
+	term := &Terminal{PTY: master, Cmd: exec.Command(argv[0], argv[1:]...)}
+	term.Cmd.Dir, term.Cmd.Env = ExpandHome(dir), append(os.Environ(), "TERM=xterm-256color")
+	term.Cmd.Stdin, term.Cmd.Stdout, term.Cmd.Stderr = slave, slave, slave
+	term.Cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	logErr(term.Resize(cols, rows))
+	if err = term.Cmd.Start(); err != nil { master.Close(); return nil, err }
+	return term, nil
+}
+
+// Resize sets the window size with TIOCSWINSZ, the kernel then sends SIGWINCH to the foreground job.
+func (t *Terminal) Resize(cols, rows int) error {
+	size := [4]uint16{uint16(rows), uint16(cols), 0, 0}
+	return ioctl(t.PTY.Fd(), syscall.TIOCSWINSZ, unsafe.Pointer(&size[0]))
+}
+
+func (t *Terminal) Close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.PTY.Close()
+	if t.Cmd.Process != nil { t.Cmd.Process.Signal(syscall.SIGHUP); t.Cmd.Wait() }
+}
+
+// pump copies PTY output into the buffer and wakes the widget after every read, so one lost wake cannot stall it; it closes the widget once the child is gone, unless Close got there first.
+func (t *Terminal) pump(win Window) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := t.PTY.Read(buf)
+		if n > 0 {
+			t.mu.Lock()
+			t.output = append(t.output, buf[:n]...)
+			t.mu.Unlock()
+			WakeWidget(win)
+		}
+		if err != nil {
+			t.mu.Lock()
+			closed := t.closed
+			t.mu.Unlock()
+			if !closed { SendWmDelete(win) }
+			return
+		}
+	}
+}
+
+func (t *Terminal) drain() string { t.mu.Lock(); defer t.mu.Unlock(); ret := string(t.output); t.output = t.output[:0]; return ret }
+
+// KeyBytes translates a widget key code, the raw X keycode behind it and the X modifier mask into terminal input.
+func KeyBytes(detail, keycode byte, modifiers uint16) []byte {
+	if code, exists := termCodes[keycode]; exists { return termCodeBytes(code, modifiers) }
+	if int(detail) >= len(Conf.Keymap) { return nil }
+	if detail >= 128 && modifiers&(xproto.ModMaskShift|xproto.ModMaskLock) == 0 { detail -= 128 } // Any modifier selects the shifted keymap half
+	key := Conf.Keymap[detail]
+	if modifiers&xproto.ModMaskControl != 0 {
+		if len(key) == 1 && key[0] >= '@' && key[0] <= '~' { return []byte{key[0] & 0x1F} }
+		if key == " " || key == "2" { return []byte{0} }
+	}
+	seq, named := termKeys[key]
+	switch {
+	case named:
+	case len(key) == 1: seq = key
+	default: return nil // N/A and bare modifiers
+	}
+	if modifiers&xproto.ModMask1 != 0 { seq = "\x1b" + seq }
+	return []byte(seq)
+}
+
+// TerminalWidget runs argv in dir on a pseudo-terminal inside a MultiRowGlyphWidget until the child exits or the window is closed.
+func TerminalWidget(title, dir string, argv []string, left, top, winWidth, winHeight int) error {
+	size := DefaultGlyphSize()
+	term, err := StartTerminal(dir, argv, winWidth/size.Width, winHeight/size.Height)
+	if err != nil { return err }
+	defer term.Close()
+	MultiRowGlyphWidgetSized(size, title, left, top, winWidth, winHeight, func(detail byte, state *MultiRowState) int {
+		if data := KeyBytes(detail, state.KeyCode, state.Modifiers); len(data) > 0 { term.PTY.Write(data) }
+		return 0
+	}, func(state *MultiRowState) {
+		state.Cursor = true
+		state.Instructions.PushBack(NOp(OpYPos, 1), NOp(OpWrap, 1))
+		state.Refresh = func() { InterpretXTerm(state, term.drain()) }
+		state.Resize = func(cols, rows int) { logErr(term.Resize(cols, rows)) }
+		go term.pump(state.Win)
+	})
+	return nil
+}
@@ -0,0 +1,266 @@
+package xgw
+import (
+	"strings"
+
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+// Rect is an area of a PanelWidget window in pixels.
+type Rect struct { X, Y, W, H int }
+
+// Element is a node of a PanelWidget tree. Leaves embed Base; containers also implement Parent.
+type Element interface {
+	Measure(w, h int) (int, int) // Preferred size within w×h; 0 along a Stack's axis asks for a share of the spare room
+	Layout(r Rect)
+	Draw(ximg *XImage) // Paints the whole Rect
+	Key(detail byte, modifiers uint16) int // Like a keypress callback: 1 repaints, 0 passes the key on, -1 closes the window
+	base() *Base
+}
+
+// Parent is an Element laying out other elements.
+type Parent interface { Children() []Element }
+
+// Base holds the state every element shares; call Invalidate after changing what an element shows.
+type Base struct {
+	Fg, Bg uint32 // 0 picks the widget palette
+	Focusable bool
+	rect Rect
+	dirty, focused bool
+}
+func (b *Base) base() *Base { return b }
+func (b *Base) Rect() Rect { return b.rect }
+func (b *Base) Focused() bool { return b.focused }
+func (b *Base) Invalidate() { b.dirty = true }
+func (b *Base) Layout(r Rect) { b.rect, b.dirty = r, true }
+func (b *Base) Key(byte, uint16) int { return 0 }
+func (b *Base) colors() (fg, bg uint32) {
+	fg, bg = b.Fg, b.Bg
+	if fg == 0 { fg = 0xffd7afaf }
+	if bg == 0 { bg = 0xff5f5f87 }
+	return
+}
+func (b *Base) fill(ximg *XImage, r Rect, color uint32) {
+	if r.W <= 0 || r.H <= 0 { return }
+	img := BlankImage(r.W, r.H)
+	for i := range img.Pix { img.Pix[i] = color }
+	ximg.XDraw(img, r.X, r.Y)
+}
+func (b *Base) text(ximg *XImage, text string, x, y, maxWidth int, fg, bg uint32) int { return drawText(ximg, EllipsizeText(DefaultGlyphSize(), 0, text, maxWidth, EllipsizeEnd), x, y, fg, bg) }
+
+// Stack places its children in a column, or a row when Horizontal, stretching them across.
+type Stack struct { Base; Horizontal bool; Gap int; Items []Element }
+func NewStack(horizontal bool, items ...Element) *Stack { return &Stack{Horizontal: horizontal, Items: items} }
+func (s *Stack) Children() []Element { return s.Items }
+func (s *Stack) Measure(w, h int) (int, int) {
+	along, across, flexible := 0, 0, false
+	for i, item := range s.Items {
+		iw, ih := item.Measure(w, h)
+		if s.Horizontal { iw, ih = ih, iw }
+		along, across, flexible = along+ih, max(across, iw), flexible || ih == 0
+		if i > 0 { along += s.Gap }
+	}
+	if flexible { along = 0 } // Grows like its flexible children
+	if s.Horizontal { return along, across }
+	return across, along
+}
+func (s *Stack) Layout(r Rect) {
+	s.Base.Layout(r)
+	sizes, flexible, spare := make([]int, len(s.Items)), 0, r.H
+	if s.Horizontal { spare = r.W }
+	spare -= s.Gap * max(len(s.Items)-1, 0)
+	for i, item := range s.Items {
+		w, h := item.Measure(r.W, r.H)
+		sizes[i] = h
+		if s.Horizontal { sizes[i] = w }
+		if sizes[i] == 0 { flexible++ }
+		spare -= sizes[i]
+	}
+	pos := r.Y
+	if s.Horizontal { pos = r.X }
+	for i, item := range s.Items {
+		size := sizes[i]
+		if size == 0 && flexible > 0 { size = max(spare, 0) / flexible; spare -= size; flexible-- }
+		if s.Horizontal { item.Layout(Rect{pos, r.Y, size, r.H}) } else { item.Layout(Rect{r.X, pos, r.W, size}) }
+		pos += size + s.Gap
+	}
+}
+func (s *Stack) Draw(ximg *XImage) { _, bg := s.colors(); s.fill(ximg, s.rect, bg) }
+
+// Box frames one element with padding and an optional one-pixel border.
+type Box struct { Base; Pad int; Border uint32; Child Element }
+func NewBox(pad int, child Element) *Box { return &Box{Pad: pad, Child: child} }
+func (b *Box) Children() []Element { return []Element{b.Child} }
+func (b *Box) inset() int { if b.Border != 0 { return b.Pad+1 }; return b.Pad }
+func (b *Box) Measure(w, h int) (int, int) {
+	cw, ch := b.Child.Measure(w-2*b.inset(), h-2*b.inset())
+	if cw > 0 { cw += 2*b.inset() }
+	if ch > 0 { ch += 2*b.inset() }
+	return cw, ch
+}
+func (b *Box) Layout(r Rect) {
+	b.Base.Layout(r)
+	in := b.inset()
+	b.Child.Layout(Rect{r.X+in, r.Y+in, max(r.W-2*in, 0), max(r.H-2*in, 0)})
+}
+func (b *Box) Draw(ximg *XImage) {
+	_, bg := b.colors()
+	b.fill(ximg, b.rect, bg)
+	if b.Border == 0 { return }
+	r := b.rect
+	for _, edge := range []Rect{{r.X, r.Y, r.W, 1}, {r.X, r.Y+r.H-1, r.W, 1}, {r.X, r.Y, 1, r.H}, {r.X+r.W-1, r.Y, 1, r.H}} { b.fill(ximg, edge, b.Border) }
+}
+
+// Label shows one line of text, ellipsized to fit.
+type Label struct { Base; Text string }
+func NewLabel(text string) *Label { return &Label{Text: text} }
+func (l *Label) SetText(text string) { if text != l.Text { l.Text = text; l.Invalidate() } }
+func (l *Label) Measure(w, h int) (int, int) { return min(TextWidth(DefaultGlyphSize(), 0, l.Text), w), GlyphHeight }
+func (l *Label) Draw(ximg *XImage) {
+	fg, bg := l.colors()
+	l.fill(ximg, l.rect, bg)
+	l.text(ximg, l.Text, l.rect.X, l.rect.Y, l.rect.W, fg, bg)
+}
+
+// List shows Items a row each, scrolled to keep Cursor visible; Return calls OnSelect.
+type List struct { Base; Items []string; Cursor, top int; OnSelect func(int) int }
+func NewList(items []string, onSelect func(int) int) *List { return &List{Base: Base{Focusable: true}, Items: items, OnSelect: onSelect} }
+func (l *List) SetItems(items []string) { l.Items, l.Cursor, l.top = items, 0, 0; l.Invalidate() }
+func (l *List) Measure(w, h int) (int, int) { return 0, 0 }
+func (l *List) Key(detail byte, modifiers uint16) int {
+	switch detail {
+	case 111: l.Cursor = max(l.Cursor-1, 0)
+	case 116: l.Cursor = min(l.Cursor+1, len(l.Items)-1)
+	case 36: if l.OnSelect != nil && l.Cursor >= 0 && l.Cursor < len(l.Items) { return l.OnSelect(l.Cursor) }; return 0
+	default: return 0
+	}
+	l.Invalidate()
+	return 1
+}
+func (l *List) Draw(ximg *XImage) {
+	fg, bg := l.colors()
+	l.fill(ximg, l.rect, bg)
+	rows := max(l.rect.H/GlyphHeight, 1)
+	l.Cursor = min(max(l.Cursor, 0), max(len(l.Items)-1, 0))
+	if l.Cursor < l.top { l.top = l.Cursor } else if l.Cursor >= l.top+rows { l.top = l.Cursor-rows+1 }
+	for i := l.top; i < len(l.Items) && i < l.top+rows; i++ {
+		y, rowFg, rowBg := l.rect.Y+(i-l.top)*GlyphHeight, fg, bg
+		if i == l.Cursor && l.focused { rowFg, rowBg = bg, fg } else if i == l.Cursor { rowFg = 0xffffaf5f }
+		l.fill(ximg, Rect{l.rect.X, y, l.rect.W, GlyphHeight}, rowBg)
+		l.text(ximg, l.Items[i], l.rect.X, y, l.rect.W, rowFg, rowBg)
+	}
+}
+
+// Input is a one-line text field edited through a LineEditor; Return passes the line to OnSubmit.
+type Input struct { Base; Prompt string; Line *LineEditor; OnSubmit func(string) int; OnChange func(string) }
+
+// NewInput keeps the history of the input under name, see NewLineEditor.
+func NewInput(prompt, name string, complete func(string) []string, onSubmit func(string) int) *Input { return &Input{Base: Base{Focusable: true}, Prompt: prompt, Line: NewLineEditor(name, complete), OnSubmit: onSubmit} }
+func (in *Input) Text() string { return in.Line.Text() }
+func (in *Input) SetText(text string) { if text != in.Line.Text() { in.Line.SetText(text); in.Invalidate() } }
+func (in *Input) Measure(w, h int) (int, int) { return 0, GlyphHeight }
+func (in *Input) Key(detail byte, modifiers uint16) int {
+	text := in.Line.Text()
+	handled, submit := in.Line.Key(detail, modifiers)
+	if !handled { return 0 }
+	in.Invalidate()
+	if submit && in.OnSubmit != nil { return in.OnSubmit(in.Line.Submit()) }
+	if in.OnChange != nil && in.Line.Text() != text { in.OnChange(in.Line.Text()) }
+	return 1
+}
+func (in *Input) Draw(ximg *XImage) {
+	fg, bg := in.colors()
+	in.fill(ximg, in.rect, bg)
+	x := in.text(ximg, in.Prompt + in.Line.Text(), in.rect.X, in.rect.Y, in.rect.W, fg, bg)
+	if names := in.Line.CandidateNames(); len(names) > 0 && x < in.rect.X+in.rect.W { in.text(ximg, "  " + strings.Join(names, " "), x, in.rect.Y, in.rect.X+in.rect.W-x, 0xff8a8a8a, bg) } // Dimmed like DuWidget's command line
+	if x := in.rect.X + TextWidth(DefaultGlyphSize(), 0, in.Prompt + in.Line.BeforeCaret()); in.focused && x+GlyphWidth <= in.rect.X+in.rect.W { ximg.XInvert(x, in.rect.Y, GlyphWidth, GlyphHeight) }
+}
+
+// Progress is a horizontal bar filled to Value, from 0 to 1, with Text over it.
+type Progress struct { Base; Value float64; Text string }
+func NewProgress(text string) *Progress { return &Progress{Text: text} }
+func (p *Progress) SetValue(value float64) { if value = min(max(value, 0), 1); value != p.Value { p.Value = value; p.Invalidate() } }
+func (p *Progress) Measure(w, h int) (int, int) { return 0, GlyphHeight }
+func (p *Progress) Draw(ximg *XImage) {
+	fg, bg := p.colors()
+	filled := int(float64(p.rect.W) * p.Value)
+	p.fill(ximg, Rect{p.rect.X, p.rect.Y, filled, p.rect.H}, fg)
+	p.fill(ximg, Rect{p.rect.X+filled, p.rect.Y, p.rect.W-filled, p.rect.H}, bg)
+	if p.Text != "" { p.text(ximg, p.Text, p.rect.X, p.rect.Y, p.rect.W, 0xff000000, fg) }
+}
+
+// Image shows Img from its top left corner, cropped to the Rect.
+type Image struct { Base; Img RGBAData }
+func NewImage(img RGBAData) *Image { return &Image{Img: img} }
+func (im *Image) SetImage(img RGBAData) { im.Img = img; im.Invalidate() }
+func (im *Image) Measure(w, h int) (int, int) { return min(im.Img.Width, w), min(im.Img.Height, h) }
+func (im *Image) Draw(ximg *XImage) {
+	_, bg := im.colors()
+	im.fill(ximg, im.rect, bg)
+	if w, h := min(im.Img.Width, im.rect.W), min(im.Img.Height, im.rect.H); w > 0 && h > 0 { ximg.XDraw(Crop(im.Img, 0, 0, w, h), im.rect.X, im.rect.Y) }
+}
+
+// Panel is the root of a PanelWidget: it owns the layout and keyboard focus.
+type Panel struct {
+	Root Element
+	Win Window
+	Refresh func() // Runs on WakeWidget or a title refresh, before repainting
+	focus []Element
+	current int
+	ximg *XImage
+}
+
+// Relayout recomputes every Rect, after elements were added or changed size.
+func (p *Panel) Relayout() {
+	p.Root.Layout(Rect{0, 0, p.ximg.Width, p.ximg.Height})
+	old := p.Focused()
+	p.focus, p.current = nil, 0
+	var walk func(Element)
+	walk = func(e Element) {
+		if e.base().Focusable { p.focus = append(p.focus, e) }
+		if parent, ok := e.(Parent); ok { for _, child := range parent.Children() { walk(child) } }
+	}
+	walk(p.Root)
+	p.Focus(old)
+}
+
+func (p *Panel) Focused() Element { if p.current < len(p.focus) { return p.focus[p.current] }; return nil }
+
+// Focus moves the keyboard focus to e when it is a focusable element of the tree.
+func (p *Panel) Focus(e Element) {
+	for i, candidate := range p.focus { if candidate == e { p.current = i } }
+	for i, candidate := range p.focus { candidate.base().focused = i == p.current; candidate.base().Invalidate() }
+}
+
+func (p *Panel) paint(e Element) {
+	if b := e.base(); b.dirty {
+		e.Draw(p.ximg)
+		b.dirty = false
+		if parent, ok := e.(Parent); ok { for _, child := range parent.Children() { child.base().Invalidate() } } // The parent painted over them
+	}
+	if parent, ok := e.(Parent); ok { for _, child := range parent.Children() { p.paint(child) } }
+}
+
+// PanelWidget shows root in a window, repainting only invalidated elements. Keys go to the focused element first, Tab and Shift+Tab move the focus and anything left over reaches keypress.
+func PanelWidget(title string, left, top, winWidth, winHeight int, root Element, keypress func(byte, *Panel) int, init func(*Panel)) {
+	panel := &Panel{Root: root}
+	UniversalWidget(title, left, top, winWidth, winHeight, func(ximg *XImage) (int, int) {
+		panel.paint(panel.Root)
+		return 0, 0
+	}, nil, func(detail byte) int {
+		if focused := panel.Focused(); focused != nil { if ret := focused.Key(detail, panel.ximg.KeyState); ret != 0 { return ret } }
+		if panel.ximg.KeyCode == 23 && len(panel.focus) > 0 { // Tab the focused element left alone, such as an Input without completion
+			step := 1
+			if panel.ximg.KeyState&xproto.ModMaskShift != 0 { step = -1 }
+			panel.Focus(panel.focus[CongruentMod(panel.current+step, len(panel.focus))])
+			return 1
+		}
+		if keypress != nil { return keypress(detail, panel) }
+		if detail == 9 { return -1 } // Escape
+		return 0
+	}, func(string) { if panel.Refresh != nil { panel.Refresh() } }, func(ximg *XImage) {
+		WindowRaiseFocuser(ximg)
+		panel.ximg, panel.Win = ximg, ximg.Win
+		panel.Relayout()
+		if init != nil { init(panel) }
+	})
+}
@@ -1,5 +1,16 @@
 package xgw
-import "strings"
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+const keyPageUp, keyPageDown, keyUp, keyLeft, keyRight, keyDown, keyC = 112, 117, 111, 113, 114, 116, 54 // X keycodes, the shifted ones beyond the reach of Conf.Keymap
+const doubleClick = 400 * time.Millisecond
+const dragThreshold = 4 // Pixels the pointer moves with the left button down before a click becomes a drag
+// UniversalWidget runs the event loop of a new window until a callback returns -1 or it is deleted; button gets the pointer position relative to the window.
 func UniversalWidget(title string, left, top, winWidth, winHeight int, paint func (*XImage) (int, int), button func (byte, int16, int16) int, keypress func (byte) int, refresh func(string), init func(*XImage)) {
 	ximg := NewXImage(left, top, winWidth, winHeight, title)
 	if ximg == nil { return }
@@ -12,7 +23,7 @@ func UniversalWidget(title string, left, top, winWidth, winHeight int, paint fun
 	}
 	paintWrap()
 	for {
-        ev, err := ximg.Conn.WaitForEvent()
+        ev, err := ximg.nextEvent()
         if err != nil || ev == nil { continue }
         switch event := ev.(type) {
 		case EXProp:
@@ -22,16 +33,33 @@ func UniversalWidget(title string, left, top, winWidth, winHeight int, paint fun
 				paintWrap()
 				SetWmName(ximg.Win, title)
 			}
-        case EXClient: if event.Type==AtomMap["WM_PROTOCOLS"] && event.Data.Data32[0]==uint32(AtomMap["WM_DELETE_WINDOW"]) && ximg.Win==event.Window { return }
+        case EXClient: 
+			if event.Type==AtomMap["WM_PROTOCOLS"] && event.Data.Data32[0]==uint32(AtomMap["WM_DELETE_WINDOW"]) && ximg.Win==event.Window { return }
+			if event.Type == AtomMap[wakeAtom] { if refresh != nil { refresh(""); paintWrap() }; continue }
+			ximg.handleXdnd(event)
+		case EXConfigure:
+			if event.Window != ximg.Win || ximg.OnResize == nil { continue }
+			switch ximg.OnResize(int(event.Width), int(event.Height)) {
+			case 1: paintWrap()
+			case -1: return
+			}
+		case EXSel: if event.Selection == AtomMap["PRIMARY"] || event.Selection == AtomMap["CLIPBOARD"] { UseClipboard(event.Requestor, event.Property, event.Target, event.Selection, event.Time) }
+		case EXSelNotify:
+			switch ximg.handleXdndData(event) {
+			case 1: paintWrap()
+			case -1: return
+			}
         case EXButton:
 			if button == nil { continue }
-			switch button(byte(event.Detail), event.RootX, event.RootY) {
+			ximg.KeyState = event.State
+			switch button(byte(event.Detail), event.EventX, event.EventY) {
 			case 1: paintWrap()
 			case -1: return
 			}
 		case EXKey:
 			if keypress == nil { continue }
 			detail := byte(event.Detail)
+			ximg.KeyState, ximg.KeyCode = event.State, byte(event.Detail)
 			if event.State != 0 { detail += 128 }
 			if int(detail) > len(Conf.Keymap) { detail = 0 }
 			switch keypress(detail) {
@@ -42,27 +70,34 @@ func UniversalWidget(title string, left, top, winWidth, winHeight int, paint fun
 	}
 }
 func WindowRaiseFocuser(ximg *XImage) { RaiseWindow(ximg.Win); FocusSet(ximg.Win) }
+func drawText(ximg *XImage, text string, x, y int, fg, bg uint32) int { for _, glyph := range ShapeRun(DefaultGlyphSize(), 0, text, fg, bg) { ximg.XDraw(glyph, x, y); x += glyph.Width }; return x }
 
-// Dequeue implements a fixed-capacity double-ended queue.
+// Dequeue implements a double-ended queue that doubles its capacity whenever a push finds it full.
 type Dequeue[T any] struct { data []T; capacity, size, head, tail int }
-func NewDequeue[T any](capacity int) *Dequeue[T] { return &Dequeue[T]{ data: make([]T, capacity), capacity: capacity, head: 0, tail: 0, size: 0} }
+func NewDequeue[T any](capacity int) *Dequeue[T] { capacity = max(capacity, 1); return &Dequeue[T]{ data: make([]T, capacity), capacity: capacity, head: 0, tail: 0, size: 0} }
 
-func (d *Dequeue[T]) PushFront(val T) error {
-	if d.size == d.capacity { return ErrFull }
+func (d *Dequeue[T]) grow() {
+	data := make([]T, d.capacity*2)
+	for i := range d.size { data[i] = d.data[(d.head+i)%d.capacity] }
+	d.data, d.capacity, d.head, d.tail = data, len(data), 0, d.size
+}
+
+func (d *Dequeue[T]) Len() int { return d.size }
+
+func (d *Dequeue[T]) PushFront(val T) {
+	if d.size == d.capacity { d.grow() }
 	d.head = (d.head - 1 + d.capacity) % d.capacity
 	d.data[d.head] = val
 	d.size++
-	return nil
 }
 
-func (d *Dequeue[T]) PushBack(vals ...T) error {
+func (d *Dequeue[T]) PushBack(vals ...T) {
 	for _, val := range vals {
-		if d.size == d.capacity { return ErrFull }
+		if d.size == d.capacity { d.grow() }
 		d.data[d.tail] = val
 		d.tail = (d.tail + 1) % d.capacity
 		d.size++
 	}
-	return nil
 }
 
 func (d *Dequeue[T]) PopFront() (T, error) {
@@ -75,10 +110,9 @@ func (d *Dequeue[T]) PopFront() (T, error) {
 
 func (d *Dequeue[T]) PopBack() (T, error) {
 	if d.size == 0 { var zero T; return zero, ErrEmpty }
-	oldTail := d.tail
 	d.tail = (d.tail - 1 + d.capacity) % d.capacity
 	d.size--
-	return d.data[oldTail], nil
+	return d.data[d.tail], nil
 }
 
 func (d *Dequeue[T]) Front() (T, error) {
@@ -93,146 +127,428 @@ func (d *Dequeue[T]) Back() (T, error) {
 
 type MultiRowState struct {
     fgColor, bgColor uint32
+    style uint8
     XPos, YPos, maxRows, winWidth, winHeight int
-    Instructions *Dequeue[string]
+    Size GlyphSize
+    Instructions *Dequeue[Op]
+    Drop func([]string, string) int // Files or text dropped onto the widget via XDND
+    Drag func() ([]string, string) // Paths or text offered when dragging out with the left button
+    Button func(detail byte, row, col int, double bool) int // Pointer button over row (from 1) and col (from 0); returns like keypress, 0 leaves the wheel to scrollback
+    Resize func(cols, rows int) // The window changed the grid size, which never grows past the size the widget was created with
+    top, bottom int // Scroll region rows, 0 means the screen edge
+    wrap bool // DECAWM, off by default so fixed layouts like DuWidget truncate instead
+    saved struct { x, y int; fg, bg uint32; style uint8 } // DECSC/DECRC
+    pending string // Incomplete escape sequence or UTF-8 rune carried to the next InterpretXTerm call
+    Win Window
+    Modifiers uint16 // X modifier mask of the key press being handled
+    KeyCode byte // Raw X keycode of that press, for keys the keymap clamps away under modifiers
+    Cursor bool // Show the cursor cell inverted
+    Refresh func() // Runs on WakeWidget or a title refresh, before repainting
+    screen *screenModel // Cells on display plus scrollback history
 }
+
+// InterpretXTerm turns terminal output into widget instructions, holding back a sequence cut off at the end of code.
 func InterpretXTerm(state *MultiRowState, code string) {
-	for _, str := range parseXTerm(code) {
-		if strings.HasPrefix(str, "\x1b[") {
-			switch str[len(str) - 1] {
-			case 'H':
-				arr := strings.Split(str[2:len(str)-1], ";")
-				if len(arr) < 2 { continue }
-				state.Instructions.PushBack("XPos="+arr[1], "YPos="+arr[0])
-			case 'K': state.Instructions.PushBack("Clear")
-			case 'm': state.Instructions.PushBack("XTerm="+str)
+	tokens := parseXTerm(state.pending + code)
+	state.pending = ""
+	if n := len(tokens); n > 0 && !xtermComplete(tokens[n-1]) { state.pending, tokens = tokens[n-1], tokens[:n-1] }
+	for _, token := range tokens { state.Instructions.PushBack(xtermOps(token)...) }
+}
+
+func xtermOps(seq string) []Op {
+	switch {
+	case seq == "\n" || seq == "\v" || seq == "\f": return []Op{{Kind: OpNewline}}
+	case seq == "\r": return []Op{{Kind: OpReturn}}
+	case seq == "\b": return []Op{NOp(OpLeft, 1)}
+	case seq == "\t": return []Op{{Kind: OpTab}}
+	case seq[0] != 0x1B && (seq[0] < 0x20 || seq[0] == 0x7F): return nil
+	case seq[0] != 0x1B: return []Op{TextOp(seq)}
+	case len(seq) == 2:
+		switch seq[1] {
+		case '7': return []Op{{Kind: OpSave}}
+		case '8': return []Op{{Kind: OpRestore}}
+		case 'D': return []Op{{Kind: OpIndex}}
+		case 'E': return []Op{{Kind: OpNewline}}
+		case 'M': return []Op{{Kind: OpReverseIndex}}
+		case 'c': return []Op{{Kind: OpReset}}
+		}
+		return nil
+	case seq[1] != '[': return nil // OSC titles, DCS and charset designations
+	}
+	final, params := seq[len(seq)-1], seq[2:len(seq)-1]
+	if strings.HasPrefix(params, "?") { // DEC private modes
+		if final != 'h' && final != 'l' { return nil }
+		on, ret := 0, []Op(nil)
+		if final == 'h' { on = 1 }
+		for _, mode := range strings.Split(params[1:], ";") {
+			switch mode {
+			case "47", "1047", "1049": ret = append(ret, NOp(OpAltScreen, on))
+			case "7": ret = append(ret, NOp(OpWrap, on))
 			}
-		} else if str == "\n" {
-			state.Instructions.PushBack("Newline")
-		} else {
-			state.Instructions.PushBack("<-" + str)
 		}
+		return ret
+	}
+	args := strings.Split(params, ";")
+	arg := func(i, def int) int { if i < len(args) { if v := ParseInt(args[i]); v > 0 { return v } }; return def }
+	n := arg(0, 1)
+	switch final {
+	case 'A': return []Op{NOp(OpUp, n)}
+	case 'B', 'e': return []Op{NOp(OpDown, n)}
+	case 'C', 'a': return []Op{NOp(OpRight, n)}
+	case 'D': return []Op{NOp(OpLeft, n)}
+	case 'E': return []Op{NOp(OpDown, n), {Kind: OpReturn}}
+	case 'F': return []Op{NOp(OpUp, n), {Kind: OpReturn}}
+	case 'G', '`': return []Op{NOp(OpXPos, n-1)}
+	case 'd': return []Op{NOp(OpYPos, n)}
+	case 'H', 'f': return []Op{NOp(OpXPos, arg(1, 1)-1), NOp(OpYPos, n)}
+	case 'J': return []Op{NOp(OpErase, arg(0, 0))}
+	case 'K': if arg(0, 0) == 0 { return []Op{{Kind: OpClear}} }; return []Op{NOp(OpEraseLine, arg(0, 0))}
+	case 's': return []Op{{Kind: OpSave}}
+	case 'u': return []Op{{Kind: OpRestore}}
+	case 'r': return []Op{{Kind: OpRegion, N: n, M: arg(1, 0)}}
+	case 'L': return []Op{NOp(OpInsertLines, n)}
+	case 'M': return []Op{NOp(OpDeleteLines, n)}
+	case 'S': return []Op{NOp(OpScrollUp, n)}
+	case 'T': return []Op{NOp(OpScrollDown, n)}
+	case '@': return []Op{NOp(OpInsertChars, n)}
+	case 'P': return []Op{NOp(OpDeleteChars, n)}
+	case 'X': return []Op{NOp(OpEraseChars, n)}
+	case 'm': return []Op{{Kind: OpStyle, Text: seq}}
+	}
+	return nil
+}
+
+// escapeEnd returns where the escape sequence starting at input[i] ends and whether its terminator was seen.
+func escapeEnd(input string, i int) (int, bool) {
+	j := i + 1
+	if j >= len(input) { return j, false }
+	switch input[j] {
+	case '[': // CSI runs to a final byte in @..~
+		for j++; j < len(input); j++ { if input[j] >= 0x40 && input[j] <= 0x7E { return j + 1, true } }
+		return j, false
+	case ']', 'P', '_', '^': // OSC, DCS, APC and PM end with BEL or ST
+		for j++; j < len(input); j++ {
+			if input[j] == 0x07 { return j + 1, true }
+			if input[j] == 0x1B && j+1 < len(input) && input[j+1] == '\\' { return j + 2, true }
+		}
+		return j, false
+	case '(', ')', '*', '+', '#', '%': return min(j+2, len(input)), j+2 <= len(input)
 	}
+	return j + 1, true
+}
+
+func xtermComplete(token string) bool {
+	if token[0] == 0x1B { _, ok := escapeEnd(token, 0); return ok }
+	for i := len(token) - 1; i >= 0 && i >= len(token)-3; i-- { if utf8.RuneStart(token[i]) { return utf8.FullRuneInString(token[i:]) } }
+	return true
 }
 
+// parseXTerm splits terminal output into printable runs, single control characters and whole escape sequences.
 func parseXTerm(input string) (ret []string) {
-	current, inEscape, inCSI, skipNext := strings.Builder{}, false, false, false // CSI = Control Sequence Introducer
-	for i, r := range input {
-		if skipNext { skipNext = false; continue }
-		switch {
-		case !inEscape && r == '\n': // Start of escape sequence
-			if current.Len() > 0 {
-				ret = append(ret, current.String())
-				current.Reset()
-			}
-			ret = append(ret, "\n")
-		case !inEscape && r == 0x1B: // Start of escape sequence
-			if current.Len() > 0 {
-				ret = append(ret, current.String())
-				current.Reset()
-			}
-			inEscape = true
-			current.WriteRune(r)
-			if i+1 < len(input) && input[i+1] == '[' {
-				inCSI, skipNext = true, true
-				current.WriteByte('[')
-			}
-		case inEscape && !inCSI:
-			current.WriteRune(r)
-			ret, inEscape = append(ret, current.String()), false
-			current.Reset()
-		case inCSI:
-			current.WriteRune(r)
-			switch r {
-			case 'm', 'H', 'f', 'A', 'B', 'C', 'D', 's', 'u', 'K', 'J', 'h', 'l':
-				ret, inEscape, inCSI = append(ret, current.String()), false, false
-				current.Reset()
-			}
-		default: current.WriteRune(r)
+	start := 0
+	flush := func(end int) { if end > start { ret = append(ret, input[start:end]) } }
+	for i := 0; i < len(input); {
+		switch c := input[i]; {
+		case c == 0x1B:
+			flush(i)
+			end, _ := escapeEnd(input, i)
+			ret, i, start = append(ret, input[i:end]), end, end
+		case c < 0x20 || c == 0x7F:
+			flush(i)
+			ret, i, start = append(ret, input[i:i+1]), i+1, i+1
+		default: i++
 		}
 	}
-	if current.Len() > 0 { ret = append(ret, current.String()) }
+	flush(len(input))
 	return
 }
 
 func MultiRowGlyphWidget(title string, left, top, winWidth, winHeight int, keypress func(byte, *MultiRowState) int, init func(*MultiRowState)) {
-    maxRows := winHeight / GlyphHeight
+	MultiRowGlyphWidgetSized(DefaultGlyphSize(), title, left, top, winWidth, winHeight, keypress, init)
+}
+
+func MultiRowGlyphWidgetSized(size GlyphSize, title string, left, top, winWidth, winHeight int, keypress func(byte, *MultiRowState) int, init func(*MultiRowState)) {
+    maxRows := winHeight / size.Height
     state := MultiRowState{
-        XPos: 0, YPos: 1, fgColor: 0xffd7afaf, bgColor: 0xff5f5f87,
-        Instructions: NewDequeue[string](winWidth/GlyphWidth*maxRows*2),
+        XPos: 0, YPos: 1, fgColor: 0xffd7afaf, bgColor: 0xff5f5f87, Size: size,
+        Instructions: NewDequeue[Op](maxRows*2),
         maxRows: maxRows, winWidth: winWidth, winHeight: winHeight,
+        screen: newScreenModel(maxRows, (winWidth+size.Width-1)/size.Width),
     }
-    state.Instructions.PushBack("ClearAll")
+    state.Instructions.PushBack(Op{Kind: OpClearAll})
 	var ximg *XImage
-	drawRune := func (aRune uint32) { 
-		if state.YPos >= state.maxRows || state.XPos >= winWidth { return }
-		glyph := GetColoredGlyph(aRune, state.fgColor, state.bgColor)
-		ximg.XDraw(glyph, state.XPos, (state.YPos-1)*GlyphHeight)
+	rowY := func(row int) int { return (row-1)*size.Height }
+	lastX := (winWidth/size.Width - 1) * size.Width
+	blank := func(x, row, w, count int) {
+		if w <= 0 || count <= 0 { return }
+		ximg.XDraw(BlankImage(w, count*size.Height), x, rowY(row))
+		col := x/size.Width
+		for r := row; r < row+count; r++ { state.screen.erase(r-1, col, (x+w+size.Width-1)/size.Width-col) }
+	}
+	region := func() (int, int) {
+		top, bottom := max(state.top, 1), state.bottom
+		if bottom <= 0 || bottom > state.maxRows { bottom = state.maxRows }
+		return top, bottom
+	}
+	scroll := func(top, bottom, n int) { // Positive n moves rows top..bottom up
+		height := bottom - top + 1
+		if n == 0 || height <= 0 { return }
+		state.screen.scroll(top-1, bottom-1, n)
+		if n > 0 {
+			n = min(n, height)
+			ximg.XCopy(0, rowY(top+n), state.winWidth, (height-n)*size.Height, 0, rowY(top))
+			blank(0, bottom-n+1, state.winWidth, n)
+		} else {
+			n = min(-n, height)
+			ximg.XCopy(0, rowY(top), state.winWidth, (height-n)*size.Height, 0, rowY(top+n))
+			blank(0, top, state.winWidth, n)
+		}
+	}
+	lineFeed := func() {
+		top, bottom := region()
+		if state.YPos == bottom { scroll(top, bottom, 1) } else if state.YPos < state.maxRows { state.YPos += 1 }
+	}
+	drawGlyph := func (glyph RGBAData, text string) {
+		if state.wrap && state.XPos + glyph.Width > state.winWidth { state.XPos = 0; lineFeed() }
+		if state.YPos < 1 || state.YPos > state.maxRows || state.XPos >= state.winWidth { return }
+		ximg.XDraw(glyph, state.XPos, (state.YPos-1)*size.Height)
+		state.screen.put(state.YPos-1, state.XPos/size.Width, (glyph.Width+size.Width-1)/size.Width, screenCell{text: text, fg: state.fgColor, bg: state.bgColor, style: state.style})
 		state.XPos += glyph.Width
 	}
-    interpret := func(instruction string) {
-        switch instruction {
-		case "Newline": state.XPos = 0; if state.YPos < state.maxRows { state.YPos += 1 }
-		case "Backspace": if state.XPos >= GlyphWidth { state.XPos -= GlyphWidth; ximg.XDraw(BlankImage(GlyphWidth, GlyphHeight), state.XPos, (state.YPos-1)*GlyphHeight) }
-        case "Clear": if state.XPos<0 || state.XPos>=state.winWidth { return }; ximg.XDraw(BlankImage(state.winWidth-state.XPos, GlyphHeight), state.XPos, (state.YPos-1)*GlyphHeight)
-        case "ClearAll": state.XPos, state.YPos = 0, 0; ximg.XDraw(BlankImage(state.winWidth, state.winHeight), 0, 0)
-        case "ClearRest": ximg.XDraw(BlankImage(state.winWidth, state.winHeight - state.YPos*GlyphHeight), 0, state.YPos*GlyphHeight)
-        default:
-            if strings.HasPrefix(instruction, "<-") { ForeachRune([]byte(instruction[2:]), func(aRune uint32){drawRune(aRune)}) }
-			if len(instruction) < 5 { return }
-			switch instruction[:5] {
-			case "YPos=": if y := ParseInt(instruction[5:]); y >= 1 && y <= state.maxRows { state.YPos = y }
-			case "XPos=": if x := ParseInt(instruction[5:]); x >= 0 && x * GlyphWidth <= state.winWidth { state.XPos = x * GlyphWidth }
-			case "XTerm": parseXTermColor(&state, strings.TrimPrefix(instruction, "XTerm="))
+	cursorX, cursorY := -1, -1
+	var lastClick struct { row, col int; at time.Time }
+	var sel struct { anchor, head [2]int; active, shown bool } // {row, col} cells of screen.view(); shown while inverted on the window
+	selRange := func() ([2]int, [2]int) {
+		if sel.head[0] < sel.anchor[0] || sel.head[0] == sel.anchor[0] && sel.head[1] < sel.anchor[1] { return sel.head, sel.anchor }
+		return sel.anchor, sel.head
+	}
+	invertSelection := func() {
+		from, to := selRange()
+		for row := from[0]; row <= to[0]; row++ {
+			first, last := 0, state.screen.cols-1
+			if row == from[0] { first = from[1] }
+			if row == to[0] { last = to[1] }
+			ximg.XInvert(first*size.Width, row*size.Height, (last-first+1)*size.Width, size.Height)
+		}
+		sel.shown = !sel.shown
+	}
+	cell := func(row, col int) [2]int { return [2]int{min(max(row, 0), state.maxRows-1), min(max(col, 0), state.screen.cols-1)} }
+	publishSelection := func() { if sel.active { ximg.SetClipboard("PRIMARY", state.screen.text(selRange())) } }
+	moveSelection := func(head [2]int) { // Extends the selection to head
+		if sel.shown { invertSelection() }
+		sel.head, sel.active = head, true
+		invertSelection()
+	}
+	redraw := func() { // Repaints the window from the cell model, at the current scrollback offset
+		cursorY, sel.active, sel.shown = -1, false, false
+		ximg.XDraw(BlankImage(state.winWidth, state.winHeight), 0, 0)
+		for row, cells := range state.screen.view() {
+			for col, cell := range cells {
+				if cell.text == "" { continue }
+				x := col*size.Width
+				for _, glyph := range ShapeRun(size, cell.style, cell.text, cell.fg, cell.bg) { ximg.XDraw(glyph, x, row*size.Height); x += glyph.Width }
+			}
+		}
+	}
+	var interpret func(Op)
+    interpret = func(op Op) {
+		n := op.N
+        switch op.Kind {
+		case OpText:
+			glyphs, texts := shapeRunText(size, state.style, op.Text, state.fgColor, state.bgColor)
+			for i, glyph := range glyphs { drawGlyph(glyph, texts[i]) }
+		case OpNewline: state.XPos = 0; lineFeed()
+		case OpIndex: lineFeed()
+		case OpReverseIndex: if top, bottom := region(); state.YPos == top { scroll(top, bottom, -1) } else if state.YPos > 1 { state.YPos -= 1 }
+		case OpReturn: state.XPos = 0
+		case OpTab: state.XPos = min((state.XPos/size.Width/8 + 1) * 8 * size.Width, lastX)
+		case OpSave: state.saved.x, state.saved.y, state.saved.fg, state.saved.bg, state.saved.style = state.XPos, state.YPos, state.fgColor, state.bgColor, state.style
+		case OpRestore: state.XPos, state.YPos, state.fgColor, state.bgColor, state.style = state.saved.x, state.saved.y, state.saved.fg, state.saved.bg, state.saved.style
+		case OpReset:
+			state.XPos, state.YPos, state.top, state.bottom, state.wrap = 0, 1, 0, 0, false
+			state.fgColor, state.bgColor, state.style = 0xffd7afaf, 0xff5f5f87, 0
+			blank(0, 1, state.winWidth, state.maxRows)
+		case OpBackspace: if state.XPos >= size.Width { state.XPos -= size.Width; blank(state.XPos, state.YPos, size.Width, 1) }
+        case OpClear: if state.XPos<0 || state.XPos>=state.winWidth { return }; blank(state.XPos, state.YPos, state.winWidth-state.XPos, 1)
+        case OpClearAll: state.XPos, state.YPos = 0, 0; ximg.XDraw(BlankImage(state.winWidth, state.winHeight), 0, 0); state.screen.clear()
+        case OpClearRest: ximg.XDraw(BlankImage(state.winWidth, state.winHeight - state.YPos*size.Height), 0, state.YPos*size.Height); for row := state.YPos; row < state.maxRows; row++ { state.screen.erase(row, 0, state.screen.cols) }
+		case OpYPos: if n >= 1 && n <= state.maxRows { state.YPos = n }
+		case OpXPos: if n >= 0 && n * size.Width <= state.winWidth { state.XPos = n * size.Width }
+		case OpStyle: parseXTermColor(&state, op.Text)
+		case OpUp: if top, _ := region(); state.YPos >= top { state.YPos = max(state.YPos-n, top) } else { state.YPos = max(state.YPos-n, 1) }
+		case OpDown: if _, bottom := region(); state.YPos <= bottom { state.YPos = min(state.YPos+n, bottom) } else { state.YPos = min(state.YPos+n, state.maxRows) }
+		case OpRight: state.XPos = min(state.XPos + n*size.Width, lastX)
+		case OpLeft: state.XPos = max(state.XPos - n*size.Width, 0)
+		case OpErase:
+			switch n {
+			case 0: blank(state.XPos, state.YPos, state.winWidth-state.XPos, 1); blank(0, state.YPos+1, state.winWidth, state.maxRows-state.YPos)
+			case 1: blank(0, 1, state.winWidth, state.YPos-1); blank(0, state.YPos, state.XPos+size.Width, 1)
+			default: blank(0, 1, state.winWidth, state.maxRows)
+			}
+		case OpEraseLine: if n == 1 { blank(0, state.YPos, state.XPos+size.Width, 1) } else { blank(0, state.YPos, state.winWidth, 1) }
+		case OpEraseChars: blank(state.XPos, state.YPos, min(n*size.Width, state.winWidth-state.XPos), 1)
+		case OpInsertChars:
+			shift, y := min(n*size.Width, state.winWidth-state.XPos), rowY(state.YPos)
+			ximg.XCopy(state.XPos, y, state.winWidth-state.XPos-shift, size.Height, state.XPos+shift, y)
+			state.screen.shift(state.YPos-1, state.XPos/size.Width, n)
+			blank(state.XPos, state.YPos, shift, 1)
+		case OpDeleteChars:
+			shift, y := min(n*size.Width, state.winWidth-state.XPos), rowY(state.YPos)
+			ximg.XCopy(state.XPos+shift, y, state.winWidth-state.XPos-shift, size.Height, state.XPos, y)
+			state.screen.shift(state.YPos-1, state.XPos/size.Width, -n)
+			blank(state.winWidth-shift, state.YPos, shift, 1)
+		case OpRegion: if op.M == 0 || n < op.M { state.top, state.bottom, state.XPos, state.YPos = n, op.M, 0, 1 }
+		case OpInsertLines: if top, bottom := region(); state.YPos >= top && state.YPos <= bottom { scroll(state.YPos, bottom, -n); state.XPos = 0 }
+		case OpDeleteLines: if top, bottom := region(); state.YPos >= top && state.YPos <= bottom { scroll(state.YPos, bottom, n); state.XPos = 0 }
+		case OpScrollUp: top, bottom := region(); scroll(top, bottom, n)
+		case OpScrollDown: top, bottom := region(); scroll(top, bottom, -n)
+		case OpWrap: state.wrap = n == 1
+		case OpAltScreen:
+			if n == 1 && state.screen.main == nil {
+				interpret(Op{Kind: OpSave})
+				state.screen.alternate(true)
+				redraw()
+			} else if n == 0 && state.screen.main != nil {
+				state.screen.alternate(false)
+				redraw()
+				interpret(Op{Kind: OpRestore})
 			}
         }
     }
-    UniversalWidget(title, left, top, winWidth, winHeight, func(ximg *XImage) (int, int) {
+    paint := func(ximg *XImage) (int, int) {
+		if cursorY >= 0 { ximg.XInvert(cursorX, cursorY, size.Width, size.Height); cursorY = -1 }
+		if sel.shown { invertSelection() }
+		if state.Instructions.Len() > 0 { sel.active = false } // New output may change the cells under it
+		if state.screen.offset > 0 && state.Instructions.Len() > 0 { state.screen.offset = 0; redraw() } // New output jumps back to the live screen
         for {
-            if state.Instructions.size == 0 { break }
+            if state.Instructions.Len() == 0 { break }
             instruction, err := state.Instructions.PopFront()
             if err == nil { interpret(instruction) }
         }
+		if sel.active { invertSelection() }
+		if state.Cursor && state.screen.offset == 0 && state.YPos >= 1 && state.YPos <= state.maxRows && state.XPos < state.winWidth {
+			cursorX, cursorY = state.XPos, rowY(state.YPos)
+			ximg.XInvert(cursorX, cursorY, size.Width, size.Height)
+		}
         return 0, 0
-    }, nil, func(detail byte) int {
+    }
+    UniversalWidget(title, left, top, winWidth, winHeight, paint, func(detail byte, x, y int16) (ret int) {
+		row, col, now := int(y)/size.Height + 1, int(x)/size.Width, time.Now()
+		double := detail == 1 && row == lastClick.row && col == lastClick.col && now.Sub(lastClick.at) < doubleClick
+		if detail == 1 { lastClick.row, lastClick.col, lastClick.at = row, col, now }
+		if double { lastClick.at = time.Time{} } // A third click starts over
+		shift := ximg.KeyState&xproto.ModMaskShift != 0 // Shift selects text even where the left button drags out
+		if state.Button != nil && !shift { ret = state.Button(detail, row, col, double) }
+		switch {
+		case ret != 0:
+		case detail == 4 && state.screen.page(3), detail == 5 && state.screen.page(-3): redraw(); ret = 1
+		}
+		if ret == -1 || detail != 1 || double { return }
+		if state.Drag != nil && !shift { // A plain click stays a click, moving on with the button down drags out
+			dragging := false
+			if ret == 1 { paint(ximg); ximg.Flush() } // Show what the click did while the button is still down
+			ximg.TrackPointer(func(mx, my int16) bool { dragging = Abs(int(mx-x)) > dragThreshold || Abs(int(my-y)) > dragThreshold; return !dragging })
+			if !dragging { return }
+			if paths, text := state.Drag(); len(paths) > 0 || text != "" { ximg.DragSource(paths, text) }
+			return
+		}
+		if sel.shown { invertSelection() }
+		sel.anchor, sel.head, sel.active = cell(row-1, col), cell(row-1, col), false
+		ximg.TrackPointer(func(x, y int16) bool {
+			if head := cell(int(y)/size.Height, int(x)/size.Width); head != sel.head || !sel.active { moveSelection(head); ximg.Flush() }
+			return true
+		})
+		publishSelection() // Once the button is up, not on every motion
+		return 1
+	}, func(detail byte) int {
+        if ximg.KeyState&xproto.ModMaskShift != 0 {
+			switch ximg.KeyCode {
+			case keyPageUp, keyPageDown:
+				page := state.maxRows-1
+				if ximg.KeyCode == keyPageDown { page = -page }
+				if state.screen.page(page) { redraw(); return 1 }
+				return 0
+			case keyUp, keyDown, keyLeft, keyRight: // Extend the selection from the text cursor
+				if !sel.active { sel.anchor, sel.head = cell(state.YPos-1, state.XPos/size.Width), cell(state.YPos-1, state.XPos/size.Width) }
+				delta := map[byte][2]int{keyUp: {-1, 0}, keyDown: {1, 0}, keyLeft: {0, -1}, keyRight: {0, 1}}[ximg.KeyCode]
+				moveSelection(cell(sel.head[0]+delta[0], sel.head[1]+delta[1]))
+				publishSelection()
+				return 1
+			case keyC: // Ctrl+Shift+C, so Ctrl+C still reaches the program
+				if ximg.KeyState&xproto.ModMaskControl == 0 { break }
+				if sel.active { ximg.SetClipboard("CLIPBOARD", state.screen.text(selRange())) }
+				return 0
+			}
+		}
         if keypress == nil { return -1 }
+        state.Modifiers, state.KeyCode = ximg.KeyState, ximg.KeyCode
         return keypress(detail, &state)
-    }, nil, func(xim *XImage) {
+    }, func(string) { if state.Refresh != nil { state.Refresh() } }, func(xim *XImage) {
 		WindowRaiseFocuser(xim)
-		ximg = xim
+		ximg, state.Win = xim, xim.Win
 		if init != nil { init(&state) }
+		xim.AcceptDrops(func(paths []string, text string, x, y int16) int {
+			if state.Drop == nil { return 0 }
+			return state.Drop(paths, text)
+		})
+		xim.OnResize = func(w, h int) int { // The backing pixmap keeps its size, so the grid only shrinks into it
+			w, h = min(w, winWidth), min(h, winHeight)
+			cols, rows := max(w/size.Width, 1), max(h/size.Height, 1)
+			oldRows, oldWidth := state.maxRows, state.winWidth
+			if rows == oldRows && (cols-1)*size.Width == lastX { return 0 }
+			state.maxRows, state.winWidth, state.winHeight, lastX = rows, w, h, (cols-1)*size.Width
+			if rows > oldRows { blank(0, oldRows+1, w, rows-oldRows) }
+			if w > oldWidth { blank(oldWidth, 1, w-oldWidth, rows) }
+			if state.YPos = min(state.YPos, rows); state.bottom > rows { state.top, state.bottom = 0, 0 }
+			if state.Resize != nil { state.Resize(cols, rows) }
+			return 1
+		}
 	})
 }
 
-type SingleRowState struct { XPos int; Instructions *Dequeue[string] }
+type SingleRowState struct { XPos int; Instructions *Dequeue[Op]; hits []BarHit }
 func SingleRowGlyphWidget(title string, left, top, winWidth int, modKeys []uint16, keypress func (byte, *SingleRowState) int, init func(*SingleRowState)) {
-	state := SingleRowState { XPos: 0, Instructions: NewDequeue[string](winWidth / GlyphWidth * 2) }
-	state.Instructions.PushBack("Clear")
+	SingleRowGlyphWidgetSized(DefaultGlyphSize(), title, left, top, winWidth, modKeys, keypress, init)
+}
+
+func SingleRowGlyphWidgetSized(size GlyphSize, title string, left, top, winWidth int, modKeys []uint16, keypress func (byte, *SingleRowState) int, init func(*SingleRowState)) {
+	state := SingleRowState { XPos: 0, Instructions: NewDequeue[Op](winWidth / size.Width * 2) }
+	state.Instructions.PushBack(Op{Kind: OpClear})
 	var ximg *XImage
 	var XPosBackup int
-	interpret := func(instruction string) {
-		switch instruction {
-		case "Clear": state.XPos = 0; ximg.XDraw(BlankImage(winWidth, GlyphHeight), 0, 0)
-		case "XPos#Save": XPosBackup = state.XPos
-		case "XPos#Load": state.XPos = XPosBackup 
-		case "Ungrab#Backspace": ximg.Ungrab(22)
-		case "Backspace": if state.XPos >= GlyphWidth  { state.XPos -= GlyphWidth; ximg.XDraw(BlankImage(GlyphWidth, GlyphHeight), state.XPos, 0) }
-		case "Raise": RaiseWindow(ximg.Win)
-		case "SetIM": ImWindow = ximg.Win
-		case "Grab#Backspace": ximg.Grab(0, 22)
-		case "Grab#Return": ximg.Grab(0, 36)
-		case "Grab#IM": for _, code := range []byte{9, 10, 11, 12, 13, 14, 20, 21, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 38, 39, 40, 41, 42, 43, 44, 45, 46, 52, 53, 54, 55, 56, 57, 58, 88, 89, 90, 91, 92} { for _, mod := range modKeys { ximg.Grab(mod, code) } }
-		default: glyph := GetColoredGlyph(StringToRune(instruction), 0xffd7afaf, 0xff5f5f87); if state.XPos + glyph.Width < winWidth { ximg.XDraw(glyph, state.XPos, 0); state.XPos += glyph.Width }
+	interpret := func(op Op) {
+		switch op.Kind {
+		case OpClear: state.XPos, state.hits = 0, nil; ximg.XDraw(BlankImage(winWidth, size.Height), 0, 0)
+		case OpBlocks:
+			hits := RenderBarBlocks(ximg, state.XPos, 0, CollectBarBlocks())
+			if len(hits) > 0 { state.XPos = hits[len(hits)-1].Right }
+			state.hits = append(state.hits, hits...)
+		case OpSaveX: XPosBackup = state.XPos
+		case OpLoadX: state.XPos = XPosBackup 
+		case OpUngrab: ximg.Ungrab(byte(op.N))
+		case OpBackspace: if state.XPos >= size.Width  { state.XPos -= size.Width; ximg.XDraw(BlankImage(size.Width, size.Height), state.XPos, 0) }
+		case OpRaise: RaiseWindow(ximg.Win)
+		case OpSetIM: ImWindow = ximg.Win
+		case OpGrab: ximg.Grab(0, byte(op.N))
+		case OpGrabIM: for _, code := range []byte{9, 10, 11, 12, 13, 14, 20, 21, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 38, 39, 40, 41, 42, 43, 44, 45, 46, 52, 53, 54, 55, 56, 57, 58, 88, 89, 90, 91, 92} { for _, mod := range modKeys { ximg.Grab(mod, code) } }
+		case OpText:
+			for _, glyph := range ShapeRun(size, 0, op.Text, 0xffd7afaf, 0xff5f5f87) {
+				if state.XPos + glyph.Width >= winWidth { break }
+				ximg.XDraw(glyph, state.XPos, 0); state.XPos += glyph.Width
+			}
 		}
 	}
-	UniversalWidget(title, left, top, winWidth, GlyphHeight, func (ximg *XImage) (int, int) {
+	UniversalWidget(title, left, top, winWidth, size.Height, func (ximg *XImage) (int, int) {
 		for {
-			if state.Instructions.size == 0 { break }
+			if state.Instructions.Len() == 0 { break }
 			instruction, err := state.Instructions.PopFront()
 			if err == nil { interpret(instruction) }
 		}
 		return 0, 0
-	}, nil, func(detail byte) int {
+	}, func(detail byte, x, y int16) int {
+		runBarAction(BarAction(state.hits, int(x), detail))
+		return 0
+	}, func(detail byte) int {
 		if keypress == nil { return 0 }
 		return keypress(detail, &state)
 	}, nil, func (xim *XImage) { 
@@ -241,7 +557,10 @@ func SingleRowGlyphWidget(title string, left, top, winWidth int, modKeys []uint1
 	})
 }
 
-func SimpleCanvasWidget(title string, img RGBAData) {
+func SimpleCanvasWidget(title string, img RGBAData) { SimpleCanvasDropWidget(title, img, nil) }
+
+// SimpleCanvasDropWidget also accepts drops, passing drop the position in img under the pointer; it returns like a button callback.
+func SimpleCanvasDropWidget(title string, img RGBAData, drop func(paths []string, text string, x, y int) int) {
     top, left := 0, 0
     UniversalWidget(title, 0, 0, Width, Height, func(ximg *XImage) (int, int) {
         if img.Width - left < Width { left = img.Width - Width }
@@ -272,5 +591,9 @@ func SimpleCanvasWidget(title string, img RGBAData) {
         default: return 0
         }
         return 1
-    }, nil, WindowRaiseFocuser)
+    }, nil, func(xim *XImage) {
+        WindowRaiseFocuser(xim)
+        if drop == nil { return }
+        xim.AcceptDrops(func(paths []string, text string, x, y int16) int { return drop(paths, text, int(x)+left, int(y)+top) })
+    })
 }
@@ -3,6 +3,7 @@ import (
 	"time"
 	"log"
 	"strings"
+	"net/url"
     "github.com/BurntSushi/xgb/xproto"
     "github.com/BurntSushi/xgb"
     "github.com/BurntSushi/xgbutil"
@@ -18,7 +19,11 @@ type EXMap = xproto.MapNotifyEvent
 type EXCreate = xproto.CreateNotifyEvent
 type EXDestroy = xproto.DestroyNotifyEvent
 type EXUnmap = xproto.UnmapNotifyEvent
+type EXSelNotify = xproto.SelectionNotifyEvent
+type EXConfigure = xproto.ConfigureNotifyEvent
 type WindowState struct { Mapped bool; BarData string }
+const wakeAtom = "_XGW_WAKE"
+type xdndState struct { source Window; target xproto.Atom; x, y int16 }
 var (
     conn *xgb.Conn
 	xu *xgbutil.XUtil
@@ -31,9 +36,18 @@ var (
     DesktopWins, StickyWins []Window
     ImWindow, Root, FocusWindow Window
 	timeDiff, selTime uint32
+	invertGC xproto.Gcontext
+	xdndAtoms = []string{"XdndAware", "XdndEnter", "XdndPosition", "XdndStatus", "XdndLeave", "XdndDrop", "XdndFinished", "XdndSelection", "XdndTypeList", "XdndActionCopy", "text/uri-list", "text/plain;charset=utf-8", "text/plain"}
 )
 func QueryTree(win Window, callback func (Window)) { if tree, err := xproto.QueryTree(conn, win).Reply(); err == nil { for _, sub := range tree.Children { callback(sub) } } }
-func QueryBytes(win Window, prop string) (ret []byte) { if reply, err := xproto.GetProperty(conn, false, win, AtomMap[prop], AtomMap["STRING"], 0, 1024).Reply(); err == nil { ret = reply.Value }; return }
+func QueryBytes(win Window, prop string) (ret []byte) {
+	for offset := uint32(0); ; { // Offset and length count 32-bit units
+		reply, err := xproto.GetProperty(conn, false, win, AtomMap[prop], AtomMap["STRING"], offset, 1024).Reply()
+		if err != nil { return }
+		if ret = append(ret, reply.Value...); reply.BytesAfter == 0 || len(reply.Value) == 0 { return }
+		offset += uint32(len(reply.Value) / 4)
+	}
+}
 func SendString(win Window, prop xproto.Atom, str string) { SendBytes(win, prop, AtomMap["STRING"], 8, []byte(str)) }
 func SendBytes(win Window, prop, propType xproto.Atom, format byte, data []byte) { xproto.ChangeProperty(conn, xproto.PropModeReplace, win, prop, propType, format, uint32(len(data)*8 / int(format)), data) }
 func FocusSet(win Window) { if win != FocusWindow && win != 0 { xproto.SetInputFocus(conn, xproto.InputFocusPointerRoot, win, 0); FocusWindow = win } }
@@ -49,7 +63,7 @@ func SetWmName(win Window, name string) { SendString(win, AtomMap["WM_NAME"], na
 func XTimeNow() uint32 { return timeDiff+uint32(time.Now().UnixMilli()) }
 func setXTime(t uint32) { timeDiff = t-uint32(time.Now().UnixMilli()) }
 func FindWindow(title string) Window { for win, _ := range WinStates { if strings.Contains(GetTitle(win), title) { return win } }; return 0 }
-func CountWindowsOfTitle(title string) (count int) { for _, state := range WinStates { if strings.Contains(state.BarData, title) { count +=1; continue } }; return }
+func CountWindowsOfTitle(title string) (count int) { for _, state := range WinStates { for _, block := range state.Blocks() { if strings.Contains(block.Text, title) { count +=1; break } } }; return }
 
 func init() {
 	initFont()
@@ -59,8 +73,11 @@ func init() {
     screen = xproto.Setup(conn).DefaultScreen(conn)
     Root, FocusWindow, Height, Width = screen.Root, screen.Root, int(screen.HeightInPixels), int(screen.WidthInPixels)
     if Width < 1000 { Scale = 1 } else { Scale = int(Width/1000) }
+	setGlyphSize(int(screen.WidthInMillimeters))
     for _, atomName := range Conf.X11Atoms{ setupAtom(atomName, true) }
 	setupAtom(Conf.BarAtom, false)
+	for _, atomName := range xdndAtoms { setupAtom(atomName, false) }
+	setupAtom(wakeAtom, false)
     xu, err = xgbutil.NewConn()
 	logAndExit(err, xproto.ChangeWindowAttributesChecked(conn, Root, xproto.CwEventMask, []uint32{uint32(xproto.EventMaskSubstructureNotify)}).Check())
 	QueryTree(Root, syncState)
@@ -90,7 +107,12 @@ func EmulateSequence(keys ...string) {
 	for i := len(keys)-1; i>=0; i-- { xtest.FakeInput(conn, xproto.KeyRelease, byte(ParseInt(keys[i])), 0, 0, 0, 0, 0) }
 } 
 
-type XImage struct { Conn *xgb.Conn; Pixmap xproto.Pixmap; Win Window; Width, Height int }
+type XImage struct { Conn *xgb.Conn; Pixmap xproto.Pixmap; Win Window; Width, Height int; KeyState uint16; KeyCode byte; OnDrop func([]string, string, int16, int16) int; OnResize func(int, int) int; dnd xdndState; pending []xgb.Event } // KeyState holds the modifiers of the last key or button press, KeyCode the raw keycode of the last key press; OnResize gets the new window size and returns like a button callback
+// nextEvent returns the events a modal loop set aside before waiting on the connection.
+func (im *XImage) nextEvent() (xgb.Event, xgb.Error) {
+	if len(im.pending) > 0 { ev := im.pending[0]; im.pending = im.pending[1:]; return ev, nil }
+	return im.Conn.WaitForEvent()
+}
 func (im *XImage) Flush() { xproto.ClearArea(xu.Conn(), false, im.Win, 0, 0, 0, 0); im.Conn.Sync() }
 func (im *XImage) Ungrab(code byte) { xproto.UngrabKey(im.Conn, xproto.Keycode(code), Root, xproto.ModMaskAny) }
 func (im *XImage) Grab(mod uint16, code byte) { xproto.GrabKey(im.Conn, false, Root, mod, xproto.Keycode(code), xproto.GrabModeAsync, xproto.GrabModeAsync) }
@@ -160,10 +182,30 @@ func (im *XImage) XDraw(img RGBAData, xpos, ypos int) {
 	}
 }
 
-func SetClipboard(selName, text string, owner Window) { 
+// XCopy moves a w x h block of the backing pixmap from (x, y) to (dx, dy), overlapping blocks included.
+func (im *XImage) XCopy(x, y, w, h, dx, dy int) {
+	if w <= 0 || h <= 0 { return }
+	xproto.CopyArea(xu.Conn(), xproto.Drawable(im.Pixmap), xproto.Drawable(im.Pixmap), xu.GC(), int16(x), int16(y), int16(dx), int16(dy), uint16(w), uint16(h))
+}
+
+// XInvert flips every pixel in the rectangle, twice restores it; used for cursors.
+func (im *XImage) XInvert(x, y, w, h int) {
+	if invertGC == 0 {
+		gc, err := xproto.NewGcontextId(xu.Conn())
+		if logErr(err) || logErr(xproto.CreateGCChecked(xu.Conn(), gc, xproto.Drawable(im.Pixmap), xproto.GcFunction, []uint32{xproto.GxInvert}).Check()) { return }
+		invertGC = gc
+	}
+	xproto.PolyFillRectangle(xu.Conn(), xproto.Drawable(im.Pixmap), invertGC, []xproto.Rectangle{{X: int16(x), Y: int16(y), Width: uint16(w), Height: uint16(h)}})
+}
+
+func SetClipboard(selName, text string, owner Window) { setSelection(conn, selName, text, owner) }
+
+// SetClipboard makes the widget window own selName, so the requests reach its UniversalWidget loop instead of the shared connection.
+func (im *XImage) SetClipboard(selName, text string) { setSelection(im.Conn, selName, text, im.Win) }
+
+func setSelection(c *xgb.Conn, selName, text string, owner Window) {
 	selTime, clipboard = XTimeNow(), text
-	log.Printf("Set clipboard %s <-%s, %v", text, clipboard, selTime)
-	xproto.SetSelectionOwner(conn, owner, AtomMap[selName], xproto.Timestamp(selTime))
+	xproto.SetSelectionOwner(c, owner, AtomMap[selName], xproto.Timestamp(selTime))
 }
 
 func UseClipboard(client Window, clientProp, target, selection xproto.Atom, timeStamp xproto.Timestamp) {
@@ -178,10 +220,19 @@ func UseClipboard(client Window, clientProp, target, selection xproto.Atom, time
 	case AtomMap["TIMESTAMP"]: propType, data, data32[0] = AtomMap["INTEGER"], Array[byte](&data32[0], 4), uint32(selTime)
 	default: propType, propFormat, data = AtomMap["UTF8_STRING"], 8, []byte(clipboard)
 	}
-	SendBytes(client, clientProp, propType, propFormat, data)
+	replySelection(client, clientProp, target, selection, timeStamp, propType, propFormat, data)
+}
+
+func replySelection(client Window, clientProp, target, selection xproto.Atom, timeStamp xproto.Timestamp, propType xproto.Atom, propFormat byte, data []byte) {
+	if clientProp != xproto.AtomNone { SendBytes(client, clientProp, propType, propFormat, data) }
 	xproto.SendEvent(conn, false, client, xproto.EventMaskNoEvent, string(xproto.SelectionNotifyEvent{Time: timeStamp, Requestor: client, Selection: selection, Target: target, Property: clientProp}.Bytes()))
 }
 
+// WakeWidget makes the UniversalWidget owning win run its refresh callback; unlike the title marker it is safe to call from other goroutines.
+func WakeWidget(win Window) {
+	xproto.SendEvent(conn, false, win, xproto.EventMaskNoEvent, string(xproto.ClientMessageEvent{Format: 8, Window: win, Type: AtomMap[wakeAtom], Data: xproto.ClientMessageDataUnion{Data8: make([]byte, 20)}}.Bytes()))
+}
+
 func SendWmDelete(win Window) {
     data32 := [5]uint32 { uint32(AtomMap["WM_DELETE_WINDOW"]), 0, 0, 0, 0 }
     xproto.SendEvent(conn, false, win, xproto.EventMaskNoEvent, string(xproto.ClientMessageEvent{Format: 32, Window: win, Type: AtomMap["WM_PROTOCOLS"], Data: xproto.ClientMessageDataUnion{Data8: Array[byte](&data32[0], 20)}}.Bytes())) // Only Data8 works
@@ -205,3 +256,157 @@ func FocusPointer()  {
     for _, win := range DesktopWins { check(win) }
     FocusSet(cand)
 }
+
+func sendXdnd(win Window, msgType string, data32 [5]uint32) {
+	xproto.SendEvent(conn, false, win, xproto.EventMaskNoEvent, string(xproto.ClientMessageEvent{Format: 32, Window: win, Type: AtomMap[msgType], Data: xproto.ClientMessageDataUnion{Data8: Array[byte](&data32[0], 20)}}.Bytes()))
+}
+
+func xdndVersion(win Window) uint32 {
+	if reply, err := xproto.GetProperty(conn, false, win, AtomMap["XdndAware"], AtomMap["ATOM"], 0, 1).Reply(); err == nil && len(reply.Value) >= 4 { return *Ptr[uint32](&reply.Value[0]) }
+	return 0
+}
+
+func xdndTarget(self Window, x, y int16) Window {
+	for win := Root; ; {
+		reply, err := xproto.TranslateCoordinates(conn, Root, win, x, y).Reply()
+		if err != nil || reply.Child == 0 { return 0 }
+		if win = reply.Child; win != self && xdndVersion(win) > 0 { return win }
+	}
+}
+
+func PathToURI(path string) string { return "file://" + (&url.URL{Path: path}).EscapedPath() }
+
+func ParseURIList(data string) (ret []string) {
+	for _, line := range strings.Split(data, "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' { continue }
+		if u, err := url.Parse(line); err == nil && u.Scheme == "file" { line = u.Path }
+		ret = append(ret, line)
+	}
+	return
+}
+
+// AcceptDrops advertises XdndAware on the window; UniversalWidget routes dropped uri lists and text to onDrop.
+func (im *XImage) AcceptDrops(onDrop func(uris []string, text string, x, y int16) int) {
+	version := uint32(5)
+	im.OnDrop = onDrop
+	SendBytes(im.Win, AtomMap["XdndAware"], AtomMap["ATOM"], 32, Array[byte](&version, 4))
+}
+
+func (im *XImage) handleXdnd(event EXClient) int {
+	data32 := event.Data.Data32
+	switch event.Type {
+	case AtomMap["XdndEnter"]:
+		types := []xproto.Atom{xproto.Atom(data32[2]), xproto.Atom(data32[3]), xproto.Atom(data32[4])}
+		if data32[1]&1 == 1 {
+			if reply, err := xproto.GetProperty(im.Conn, false, Window(data32[0]), AtomMap["XdndTypeList"], AtomMap["ATOM"], 0, 64).Reply(); err == nil && len(reply.Value) >= 4 { types = Array[xproto.Atom](&reply.Value[0], len(reply.Value)/4) }
+		}
+		im.dnd = xdndState{source: Window(data32[0])}
+		for _, name := range []string{"text/uri-list", "UTF8_STRING", "text/plain;charset=utf-8", "text/plain"} {
+			for _, atom := range types { if atom == AtomMap[name] && im.dnd.target == 0 { im.dnd.target = atom } }
+		}
+	case AtomMap["XdndPosition"]:
+		if Window(data32[0]) != im.dnd.source { return 0 }
+		var accept uint32
+		if im.dnd.target != 0 && im.OnDrop != nil { accept = 1 }
+		im.dnd.x, im.dnd.y = int16(data32[2]>>16), int16(data32[2]&0xFFFF)
+		sendXdnd(im.dnd.source, "XdndStatus", [5]uint32{uint32(im.Win), accept, 0, 0, uint32(AtomMap["XdndActionCopy"])})
+	case AtomMap["XdndLeave"]: im.dnd = xdndState{}
+	case AtomMap["XdndDrop"]:
+		if Window(data32[0]) != im.dnd.source { return 0 }
+		if im.dnd.target == 0 || im.OnDrop == nil { sendXdnd(im.dnd.source, "XdndFinished", [5]uint32{uint32(im.Win), 0, 0, 0, 0}); im.dnd = xdndState{}; return 0 }
+		xproto.ConvertSelection(im.Conn, im.Win, AtomMap["XdndSelection"], im.dnd.target, AtomMap["XdndSelection"], xproto.Timestamp(data32[2]))
+	}
+	return 0
+}
+
+func (im *XImage) handleXdndData(event EXSelNotify) (ret int) {
+	if event.Selection != AtomMap["XdndSelection"] || im.dnd.source == 0 { return }
+	state, accepted := im.dnd, uint32(0)
+	im.dnd = xdndState{}
+	if event.Property != xproto.AtomNone {
+		if reply, err := xproto.GetProperty(im.Conn, true, im.Win, event.Property, xproto.GetPropertyTypeAny, 0, 1<<20).Reply(); err == nil && im.OnDrop != nil {
+			if accepted = 1; state.target == AtomMap["text/uri-list"] { ret = im.OnDrop(ParseURIList(string(reply.Value)), "", state.x, state.y) } else { ret = im.OnDrop(nil, string(reply.Value), state.x, state.y) }
+		}
+	}
+	sendXdnd(state.source, "XdndFinished", [5]uint32{uint32(im.Win), accepted, accepted*uint32(AtomMap["XdndActionCopy"]), 0, 0})
+	return
+}
+
+// TrackPointer grabs the pointer until a button is released or motion returns false, passing each motion in window coordinates; other events wait for the widget loop.
+func (im *XImage) TrackPointer(motion func(x, y int16) bool) {
+	if reply, err := xproto.GrabPointer(im.Conn, false, im.Win, xproto.EventMaskPointerMotion|xproto.EventMaskButtonRelease, xproto.GrabModeAsync, xproto.GrabModeAsync, 0, 0, xproto.TimeCurrentTime).Reply(); err != nil || reply.Status != xproto.GrabStatusSuccess { return }
+	defer xproto.UngrabPointer(im.Conn, xproto.TimeCurrentTime)
+	for {
+		ev, err := im.Conn.WaitForEvent()
+		if err != nil || ev == nil { if err == nil { return }; continue }
+		switch event := ev.(type) {
+		case xproto.MotionNotifyEvent: if !motion(event.EventX, event.EventY) { return }
+		case xproto.ButtonReleaseEvent: return
+		default: im.pending = append(im.pending, ev)
+		}
+	}
+}
+
+// DragSource runs a modal XDND drag offering file paths as text/uri-list and/or plain text; it returns true when the target accepted the drop.
+func (im *XImage) DragSource(paths []string, text string) bool {
+	offers, types := make(map[xproto.Atom][]byte), []xproto.Atom{}
+	if len(paths) > 0 {
+		list := strings.Builder{}
+		for _, path := range paths { list.WriteString(PathToURI(path) + "\r\n") }
+		offers[AtomMap["text/uri-list"]], types = []byte(list.String()), append(types, AtomMap["text/uri-list"])
+	}
+	if text != "" { for _, name := range []string{"UTF8_STRING", "text/plain;charset=utf-8", "text/plain"} { offers[AtomMap[name]], types = []byte(text), append(types, AtomMap[name]) } }
+	if len(types) == 0 { return false }
+	xproto.SetSelectionOwner(im.Conn, im.Win, AtomMap["XdndSelection"], xproto.TimeCurrentTime)
+	SendBytes(im.Win, AtomMap["XdndTypeList"], AtomMap["ATOM"], 32, Array[byte](&types[0], 4*len(types)))
+	if reply, err := xproto.GrabPointer(im.Conn, false, im.Win, xproto.EventMaskPointerMotion|xproto.EventMaskButtonRelease, xproto.GrabModeAsync, xproto.GrabModeAsync, 0, 0, xproto.TimeCurrentTime).Reply(); err != nil || reply.Status != xproto.GrabStatusSuccess { return false }
+	defer xproto.UngrabPointer(im.Conn, xproto.TimeCurrentTime)
+	var target Window
+	var typeFlag uint32
+	if len(types) > 3 { typeFlag = 1 }
+	accepted, dropped := false, false
+	for {
+		ev, err := im.Conn.WaitForEvent()
+		if err != nil || ev == nil { if err == nil { return false }; continue }
+		switch event := ev.(type) {
+		case xproto.MotionNotifyEvent:
+			if dropped { continue }
+			if win := xdndTarget(im.Win, event.RootX, event.RootY); win != target {
+				if target != 0 { sendXdnd(target, "XdndLeave", [5]uint32{uint32(im.Win), 0, 0, 0, 0}) }
+				if target, accepted = win, false; target != 0 {
+					enter := [5]uint32{uint32(im.Win), 5<<24 | typeFlag, 0, 0, 0}
+					for i := 0; i < len(types) && i < 3; i++ { enter[2+i] = uint32(types[i]) }
+					sendXdnd(target, "XdndEnter", enter)
+				}
+			}
+			if target != 0 { sendXdnd(target, "XdndPosition", [5]uint32{uint32(im.Win), 0, uint32(uint16(event.RootX))<<16 | uint32(uint16(event.RootY)), uint32(event.Time), uint32(AtomMap["XdndActionCopy"])}) }
+		case xproto.ButtonReleaseEvent:
+			if dropped { continue }
+			if target == 0 || !accepted {
+				if target != 0 { sendXdnd(target, "XdndLeave", [5]uint32{uint32(im.Win), 0, 0, 0, 0}) }
+				return false
+			}
+			sendXdnd(target, "XdndDrop", [5]uint32{uint32(im.Win), 0, uint32(event.Time), 0, 0})
+			xproto.UngrabPointer(im.Conn, xproto.TimeCurrentTime)
+			dropped = true
+			time.AfterFunc(5*time.Second, func() { sendXdnd(im.Win, "XdndFinished", [5]uint32{0, 0, 0, 0, 0}) }) // Give up on targets that never finish
+		case EXClient:
+			switch event.Type {
+			case AtomMap["XdndStatus"]: if Window(event.Data.Data32[0]) == target { accepted = event.Data.Data32[1]&1 == 1 }
+			case AtomMap["XdndFinished"]: if dropped { return Window(event.Data.Data32[0]) == target && event.Data.Data32[1]&1 == 1 }
+			default: im.pending = append(im.pending, ev)
+			}
+		case EXSel:
+			if event.Selection != AtomMap["XdndSelection"] { im.pending = append(im.pending, ev); continue }
+			property := event.Property
+			if property == xproto.AtomNone { property = event.Target }
+			if data, exists := offers[event.Target]; exists {
+				replySelection(event.Requestor, property, event.Target, event.Selection, event.Time, event.Target, 8, data)
+			} else if event.Target == AtomMap["TARGETS"] {
+				replySelection(event.Requestor, property, event.Target, event.Selection, event.Time, AtomMap["ATOM"], 32, Array[byte](&types[0], 4*len(types)))
+			} else {
+				replySelection(event.Requestor, xproto.AtomNone, event.Target, event.Selection, event.Time, 0, 8, nil)
+			}
+		}
+	}
+}